@@ -14,14 +14,30 @@ import (
 type Config struct {
 	Database databases.Config
 	Workload workloads.Config
+	Cluster  ClusterConfig
 }
 
-func ReadConfig() (config Config) {
+// ReadConfig parses the command line: either "blurr workload.conf",
+// "blurr setup|teardown|coordinator workload.conf" or "blurr agent address".
+// Agents get their config from the coordinator.
+func ReadConfig() (command string, config Config) {
 	flag.Usage = func() {
-		fmt.Println("Usage: blurr workload.conf")
+		fmt.Println("Usage: blurr [setup|teardown|coordinator] workload.conf")
+		fmt.Println("       blurr agent host:port")
 	}
 	flag.Parse()
-	workload_path := flag.Arg(0)
+	command, workload_path := "run", flag.Arg(0)
+	if flag.NArg() > 1 {
+		command, workload_path = flag.Arg(0), flag.Arg(1)
+	}
+	switch command {
+	case "run", "setup", "teardown", "coordinator":
+	case "agent":
+		return
+	default:
+		flag.Usage()
+		log.Fatalf("Unknown command: %s", command)
+	}
 
 	workload, err := ioutil.ReadFile(workload_path)
 	if err != nil {
@@ -32,18 +48,93 @@ func ReadConfig() (config Config) {
 	if err != nil {
 		log.Fatal(err)
 	}
+	if err = config.Database.LoadCredentials(); err != nil {
+		log.Fatal(err)
+	}
 
 	if config.Workload.ReadPercentage+config.Workload.UpdatePercentage+
-		config.Workload.DeletePercentage > 0 && config.Workload.Records == 0 {
+		config.Workload.DeletePercentage+config.Workload.ScanPercentage > 0 &&
+		config.Workload.Records == 0 {
 		log.Fatal("Please specify non-zero 'Records'")
 	}
 
-	if config.Workload.Workers > 0 {
-		config.Workload.Throughput /= config.Workload.Workers
+	switch config.Workload.ScanPagination {
+	case "", databases.OffsetPagination, databases.KeysetPagination:
+	case databases.CursorPagination:
+		if config.Database.Driver == "Couchbase" {
+			log.Fatal("Cursor pagination is not supported by Couchbase views")
+		}
+	default:
+		log.Fatalf("Unknown pagination: %s", config.Workload.ScanPagination)
+	}
+
+	if config.Workload.ValidateQueries && config.Workload.Type != "N1QL" {
+		log.Fatal("Query validation is only supported by N1QL workload")
+	}
+
+	clients := config.Workload.ClientCount
+	if clients < 0 || config.Workload.ClientIndex < 0 ||
+		(clients > 0 && config.Workload.ClientIndex >= clients) {
+		log.Fatal("'ClientIndex' must be between 0 and 'ClientCount' - 1")
+	}
+	if command == "coordinator" {
+		if len(config.Cluster.Agents) == 0 {
+			log.Fatal("Please specify 'Agents' of the cluster")
+		}
+		if clients > 0 {
+			log.Fatal("'ClientIndex' and 'ClientCount' are assigned by the coordinator")
+		}
+		clients = len(config.Cluster.Agents)
+	}
+	if clients > 1 {
+		if config.Workload.ValidateQueries {
+			log.Fatal("Query validation is not supported by multiple clients")
+		}
+		if config.Workload.Verify || config.Workload.Staleness {
+			log.Fatal("Verification is not supported by multiple clients")
+		}
+	}
+
+	totalWeight := 0
+	for _, index := range config.Workload.Indexes {
+		weight, ok := config.Workload.QueryWeights[index]
+		if !ok {
+			weight = 1
+		}
+		if weight < 0 {
+			log.Fatalf("Negative weight of query %s", index)
+		}
+		totalWeight += weight
+	}
+	for index := range config.Workload.QueryWeights {
+		found := false
+		for _, name := range config.Workload.Indexes {
+			found = found || name == index
+		}
+		if !found {
+			log.Fatalf("Weight of query %s which is not listed in 'Indexes'", index)
+		}
 	}
-	if config.Workload.QueryWorkers > 0 {
-		config.Workload.QueryThroughput /= config.Workload.QueryWorkers
+	if len(config.Workload.Indexes) > 0 && totalWeight == 0 {
+		log.Fatal("Please specify non-zero 'QueryWeights'")
 	}
 
 	return
 }
+
+// LoadCatalog loads the query catalog and validates the queries listed in
+// Workload.Indexes against it. The catalog is passed on to the drivers.
+func LoadCatalog(config *Config) *databases.Catalog {
+	catalog, err := databases.LoadCatalog(config.Database.Catalog)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := workloads.ValidateCatalog(catalog); err != nil {
+		log.Fatalf("query catalog: %v", err)
+	}
+	if err := catalog.Check(config.Database.Driver, config.Workload.Indexes); err != nil {
+		log.Fatalf("query catalog: %v", err)
+	}
+	config.Database.QueryCatalog = catalog
+	return catalog
+}
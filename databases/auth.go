@@ -0,0 +1,89 @@
+package databases
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+)
+
+// Credentials from the environment take precedence over Config.Credentials,
+// which in turn takes precedence over Username and Password of the config.
+const (
+	UsernameEnv = "BLURR_USERNAME"
+	PasswordEnv = "BLURR_PASSWORD"
+)
+
+type TLSConfig struct {
+	Enabled    bool
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	SkipVerify bool
+}
+
+// LoadCredentials resolves Username and Password from the credentials file
+// ({"Username": ..., "Password": ...}) and the environment.
+func (c *Config) LoadCredentials() error {
+	if c.Credentials != "" {
+		data, err := ioutil.ReadFile(c.Credentials)
+		if err != nil {
+			return err
+		}
+		credentials := struct{ Username, Password string }{}
+		if err := json.Unmarshal(data, &credentials); err != nil {
+			return fmt.Errorf("%s: %v", c.Credentials, err)
+		}
+		c.Username, c.Password = credentials.Username, credentials.Password
+	}
+	if username := os.Getenv(UsernameEnv); username != "" {
+		c.Username = username
+	}
+	if password := os.Getenv(PasswordEnv); password != "" {
+		c.Password = password
+	}
+	return nil
+}
+
+// config returns nil when TLS is disabled.
+func (c TLSConfig) config() (*tls.Config, error) {
+	if !c.Enabled {
+		return nil, nil
+	}
+	config := &tls.Config{InsecureSkipVerify: c.SkipVerify}
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", c.CAFile)
+		}
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// tlsClient performs the TLS handshake on a connection to address.
+func tlsClient(conn net.Conn, config *tls.Config, address string) (net.Conn, error) {
+	config = config.Clone()
+	if config.ServerName == "" {
+		config.ServerName, _, _ = net.SplitHostPort(address)
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
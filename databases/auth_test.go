@@ -0,0 +1,27 @@
+package databases
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestLoadCredentials(t *testing.T) {
+	file, err := ioutil.TempFile("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`{"Username": "file", "Password": "secret"}`)
+	file.Close()
+
+	config := Config{Username: "config", Password: "config", Credentials: file.Name()}
+	os.Setenv(PasswordEnv, "env")
+	defer os.Unsetenv(PasswordEnv)
+	if err := config.LoadCredentials(); err != nil {
+		t.Fatal(err)
+	}
+	if config.Username != "file" || config.Password != "env" {
+		t.Errorf("unexpected credentials: %s:%s", config.Username, config.Password)
+	}
+}
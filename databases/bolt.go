@@ -0,0 +1,277 @@
+package databases
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type BoltConfig struct {
+	Directory string
+	NoSync    bool
+	Batch     bool
+}
+
+// Bolt is an embedded key-value store driver, it measures the overhead of the
+// generator and the harness and compares storage engines locally. Documents
+// are stored in the Database.Table bucket of <Directory>/<Name>.db.
+type Bolt struct {
+	DB     *bolt.DB
+	Bucket []byte
+	path   string
+	config BoltConfig
+}
+
+// boltFile is a database file shared by the drivers of a process, Bolt locks
+// files exclusively.
+type boltFile struct {
+	db   *bolt.DB
+	refs int
+}
+
+var (
+	boltFiles     = map[string]*boltFile{}
+	boltFilesLock sync.Mutex
+)
+
+func openBolt(path string, noSync bool) (*bolt.DB, error) {
+	boltFilesLock.Lock()
+	defer boltFilesLock.Unlock()
+	if file, ok := boltFiles[path]; ok {
+		file.refs++
+		return file.db, nil
+	}
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second, NoSync: noSync})
+	if err != nil {
+		return nil, err
+	}
+	boltFiles[path] = &boltFile{db: db, refs: 1}
+	return db, nil
+}
+
+func closeBolt(path string) error {
+	boltFilesLock.Lock()
+	defer boltFilesLock.Unlock()
+	file, ok := boltFiles[path]
+	if !ok {
+		return nil
+	}
+	if file.refs--; file.refs > 0 {
+		return nil
+	}
+	delete(boltFiles, path)
+	return file.db.Close()
+}
+
+func (b *Bolt) Init(config Config) {
+	b.config = config.Bolt
+	directory := config.Bolt.Directory
+	if directory == "" {
+		directory = "."
+	}
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		log.Fatal(err)
+	}
+	name := config.Name
+	if name == "" {
+		name = "blurr"
+	}
+	b.path = filepath.Join(directory, name+".db")
+	b.Bucket = []byte(config.Table)
+
+	var err error
+	if b.DB, err = openBolt(b.path, config.Bolt.NoSync); err != nil {
+		log.Fatal(err)
+	}
+	err = b.DB.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(b.Bucket)
+		return err
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func (b *Bolt) Shutdown() {
+	if err := closeBolt(b.path); err != nil {
+		log.Print(err)
+	}
+}
+
+func (b *Bolt) Durability() string {
+	if b.config.NoSync {
+		return "writes: no fsync"
+	}
+	return "writes: fsync on commit"
+}
+
+// ReportSummary prints the size of the database file.
+func (b *Bolt) ReportSummary() {
+	info, err := os.Stat(b.path)
+	if err != nil {
+		return
+	}
+	fmt.Println("Storage:")
+	fmt.Printf("\tFile: %s\n", b.path)
+	fmt.Printf("\tSize: %.1f MB\n", float64(info.Size())/(1<<20))
+}
+
+// encodeRecord prefixes the encoded value with its expiration time in Unix
+// seconds, zero if the value never expires.
+func encodeRecord(value interface{}, expiry int) ([]byte, error) {
+	payload, ok := value.([]byte)
+	if !ok {
+		var err error
+		if payload, err = json.Marshal(value); err != nil {
+			return nil, err
+		}
+	}
+	record := make([]byte, 8+len(payload))
+	if expiry > 0 {
+		binary.BigEndian.PutUint64(record, uint64(time.Now().Unix()+int64(expiry)))
+	}
+	copy(record[8:], payload)
+	return record, nil
+}
+
+// decodeRecord returns the payload of a record, false if it has expired.
+func decodeRecord(record []byte, now time.Time) ([]byte, bool) {
+	if len(record) < 8 {
+		return nil, false
+	}
+	expiresAt := binary.BigEndian.Uint64(record)
+	if expiresAt > 0 && int64(expiresAt) <= now.Unix() {
+		return nil, false
+	}
+	return record[8:], true
+}
+
+func (b *Bolt) update(fn func(*bolt.Bucket) error) error {
+	tx := func(tx *bolt.Tx) error {
+		return fn(tx.Bucket(b.Bucket))
+	}
+	var err error
+	if b.config.Batch {
+		err = b.DB.Batch(tx)
+	} else {
+		err = b.DB.Update(tx)
+	}
+	return classify(ServerError, err)
+}
+
+func (b *Bolt) put(key string, value interface{}, expiry int) error {
+	record, err := encodeRecord(value, expiry)
+	if err != nil {
+		return classify(ServerError, err)
+	}
+	return b.update(func(bucket *bolt.Bucket) error {
+		return bucket.Put([]byte(key), record)
+	})
+}
+
+func (b *Bolt) Create(key string, value interface{}, expiry int) error {
+	return b.put(key, value, expiry)
+}
+
+func (b *Bolt) Read(key string) (interface{}, error) {
+	var payload []byte
+	err := b.DB.View(func(tx *bolt.Tx) error {
+		record := tx.Bucket(b.Bucket).Get([]byte(key))
+		data, ok := decodeRecord(record, time.Now())
+		if !ok {
+			return ErrNotFound
+		}
+		// Values are only valid during the transaction.
+		payload = append([]byte(nil), data...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decodeRaw(payload)
+}
+
+func (b *Bolt) Update(key string, value interface{}, expiry int) error {
+	return b.put(key, value, expiry)
+}
+
+// Delete fails with ErrNotFound for expired records like Read.
+func (b *Bolt) Delete(key string) error {
+	return b.update(func(bucket *bolt.Bucket) error {
+		if _, ok := decodeRecord(bucket.Get([]byte(key)), time.Now()); !ok {
+			return ErrNotFound
+		}
+		return bucket.Delete([]byte(key))
+	})
+}
+
+// Query is not supported, Bolt has no secondary indexes.
+func (b *Bolt) Query(key string, args []interface{}) ([]map[string]interface{}, error) {
+	return nil, &Error{ServerError, fmt.Errorf("queries are not supported by the Bolt driver")}
+}
+
+// Scan iterates the bucket in key order, skipping expired records. Offset and
+// Keyset pages use a read transaction per page, Cursor scans a single one.
+func (b *Bolt) Scan(startKey string, options ScanOptions) (int, error) {
+	scanned := 0
+	page := func(seek []byte, skip int, after bool, limit int) ([]byte, int, error) {
+		var last []byte
+		count := 0
+		err := b.DB.View(func(tx *bolt.Tx) error {
+			now := time.Now()
+			c := tx.Bucket(b.Bucket).Cursor()
+			k, v := c.Seek(seek)
+			if after && k != nil && string(k) == string(seek) {
+				k, v = c.Next()
+			}
+			for ; k != nil && count < limit; k, v = c.Next() {
+				if _, ok := decodeRecord(v, now); !ok {
+					continue
+				}
+				if skip > 0 {
+					skip--
+					continue
+				}
+				last = append(last[:0], k...)
+				count++
+			}
+			return nil
+		})
+		return last, count, classify(ServerError, err)
+	}
+
+	if options.Pagination == CursorPagination {
+		_, count, err := page([]byte(startKey), 0, false, options.Length)
+		return count, err
+	}
+	last := []byte(startKey)
+	for scanned < options.Length {
+		limit := options.pageLimit(scanned)
+		var count int
+		var err error
+		if options.Pagination == KeysetPagination {
+			var next []byte
+			next, count, err = page(last, 0, scanned > 0, limit)
+			if next != nil {
+				last = next
+			}
+		} else {
+			_, count, err = page([]byte(startKey), scanned, false, limit)
+		}
+		if err != nil {
+			return scanned, err
+		}
+		scanned += count
+		if count < limit {
+			break
+		}
+	}
+	return scanned, nil
+}
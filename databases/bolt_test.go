@@ -0,0 +1,105 @@
+package databases
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltRecord(t *testing.T) {
+	record, err := encodeRecord(map[string]interface{}{"city": "Paris"}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, ok := decodeRecord(record, time.Now())
+	if !ok || string(payload) != `{"city":"Paris"}` {
+		t.Errorf("unexpected payload: %q", payload)
+	}
+	if _, ok := decodeRecord(record, time.Now().Add(11*time.Second)); ok {
+		t.Error("expired record was returned")
+	}
+
+	record, err = encodeRecord([]byte{1, 2, 3}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload, ok := decodeRecord(record, time.Now().Add(time.Hour)); !ok || len(payload) != 3 {
+		t.Errorf("unexpected payload: %v", payload)
+	}
+}
+
+func TestBolt(t *testing.T) {
+	config := Config{Name: "test", Table: "docs", Bolt: BoltConfig{Directory: t.TempDir(), NoSync: true}}
+	db := &Bolt{}
+	db.Init(config)
+	other := &Bolt{}
+	other.Init(config)
+	if other.DB != db.DB || boltFiles[db.path].refs != 2 {
+		t.Fatal("database file is not shared")
+	}
+
+	if err := db.Create("k0", map[string]interface{}{"city": "Paris"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := other.Read("k0"); err != nil || value.(map[string]interface{})["city"] != "Paris" {
+		t.Errorf("read: %v, %v", value, err)
+	}
+	if err := db.Update("k0", []byte("payload"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := db.Read("k0"); err != nil || string(value.([]byte)) != "payload" {
+		t.Errorf("read after update: %v, %v", value, err)
+	}
+	if err := db.Delete("k0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Read("k0"); err != ErrNotFound {
+		t.Errorf("read after delete: %v", err)
+	}
+	if err := db.Delete("k0"); err != ErrNotFound {
+		t.Errorf("delete of a missing key: %v", err)
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := db.Create(fmt.Sprintf("k%d", i), map[string]interface{}{}, 60); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Expire k5 a second ago.
+	err := db.DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(db.Bucket)
+		record := append([]byte(nil), bucket.Get([]byte("k5"))...)
+		binary.BigEndian.PutUint64(record, uint64(time.Now().Unix()-1))
+		return bucket.Put([]byte("k5"), record)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Read("k5"); err != ErrNotFound {
+		t.Errorf("read of an expired key: %v", err)
+	}
+	if err := db.Delete("k5"); err != ErrNotFound {
+		t.Errorf("delete of an expired key: %v", err)
+	}
+
+	for _, pagination := range []string{OffsetPagination, KeysetPagination, CursorPagination} {
+		for length, expected := range map[int]int{4: 4, 100: 7} {
+			scanned, err := db.Scan("k2", ScanOptions{Length: length, PageSize: 3, Pagination: pagination})
+			if err != nil || scanned != expected {
+				t.Errorf("%s scan of %d: %d, %v", pagination, length, scanned, err)
+			}
+		}
+	}
+
+	other.Shutdown()
+	if boltFiles[db.path].refs != 1 {
+		t.Error("database file is not referenced")
+	}
+	db.Shutdown()
+	if _, ok := boltFiles[db.path]; ok {
+		t.Error("database file is not closed")
+	}
+}
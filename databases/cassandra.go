@@ -1,9 +1,13 @@
 package databases
 
 import (
+	"encoding/json"
+	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/carloscm/gossie/src/gossie"
 )
@@ -11,63 +15,176 @@ import (
 type Cassandra struct {
 	Pool         gossie.ConnectionPool
 	ColumnFamily string
+	WriteLevel   string
+	ReadLevel    string
+}
+
+// thriftLocalOne is the Thrift value of LOCAL_ONE, which gossie does not
+// define.
+const thriftLocalOne = 11
+
+var thriftLevels = map[string]int{
+	"ANY":          gossie.CONSISTENCY_ANY,
+	"ONE":          gossie.CONSISTENCY_ONE,
+	"LOCAL_ONE":    thriftLocalOne,
+	"TWO":          gossie.CONSISTENCY_TWO,
+	"THREE":        gossie.CONSISTENCY_THREE,
+	"QUORUM":       gossie.CONSISTENCY_QUORUM,
+	"ALL":          gossie.CONSISTENCY_ALL,
+	"LOCAL_QUORUM": gossie.CONSISTENCY_LOCAL_QUORUM,
+	"EACH_QUORUM":  gossie.CONSISTENCY_EACH_QUORUM,
+}
+
+// thriftLevel defaults to QUORUM like the CQL driver.
+func thriftLevel(level string) string {
+	if level == "" {
+		return "QUORUM"
+	}
+	level = strings.ToUpper(level)
+	if _, ok := thriftLevels[level]; !ok {
+		log.Fatalf("Unknown consistency level: %s", level)
+	}
+	return level
 }
 
 type Column struct {
 	Key   string `cf:"default" key:"Key" value:"Value"`
-	Value string
+	Value []byte
 }
 
+// Init uses the pool size from the client configuration or GOMAXPROCS. gossie
+// dials connections itself, so they are not tracked.
 func (cs *Cassandra) Init(config Config) {
+	config.Client.sessionReuse("Cassandra", PooledSessions)
+	if config.TLS.Enabled {
+		log.Fatal("Cassandra driver does not support TLS, use the CQL driver")
+	}
 	var err error
-	var pool_size int64
+	pool_size := int64(config.Client.PoolSize)
 	max_cores := os.Getenv("GOMAXPROCS")
-	if len(max_cores) > 0 {
+	if pool_size == 0 && len(max_cores) > 0 {
 		pool_size, err = strconv.ParseInt(max_cores, 10, 0)
 		if err != nil {
 			log.Fatal(err)
 		}
 	}
-	cs.Pool, err = gossie.NewConnectionPool(config.Addresses, config.Name,
-		gossie.PoolOptions{Size: int(pool_size), Timeout: 5000})
+	options := gossie.PoolOptions{
+		Size:    int(pool_size),
+		Timeout: int(config.Client.connectTimeout(5*time.Second) / time.Millisecond),
+	}
+	if config.Username != "" {
+		options.Authentication = map[string]string{
+			"username": config.Username,
+			"password": config.Password,
+		}
+	}
+	cs.Pool, err = gossie.NewConnectionPool(config.Addresses, config.Name, options)
 	if err != nil {
 		log.Fatal(err)
 	}
 	cs.ColumnFamily = config.Table
+	cs.WriteLevel = thriftLevel(config.Durability.Write)
+	cs.ReadLevel = thriftLevel(config.Durability.Read)
+}
+
+func (cs *Cassandra) Durability() string {
+	return fmt.Sprintf("writes: %s; reads: %s", cs.WriteLevel, cs.ReadLevel)
+}
+
+func (cs *Cassandra) writer() gossie.Writer {
+	return cs.Pool.Writer().ConsistencyLevel(thriftLevels[cs.WriteLevel])
+}
+
+func (cs *Cassandra) reader() gossie.Reader {
+	return cs.Pool.Reader().ConsistencyLevel(thriftLevels[cs.ReadLevel])
 }
 
 func (cs *Cassandra) Shutdown() {
 	cs.Pool.Close()
 }
 
-func valueToRow(key string, value map[string]interface{}) *gossie.Row {
+// valueToRow stores binary payloads as is, single-field documents produced by
+// the Default workload as their string value and any other document as JSON.
+func valueToRow(key string, value interface{}) (*gossie.Row, error) {
+	var data []byte
+	switch v := value.(type) {
+	case []byte:
+		data = v
+	case map[string]interface{}:
+		if s, ok := v[key].(string); ok && len(v) == 1 {
+			data = []byte(s)
+		} else {
+			var err error
+			if data, err = json.Marshal(v); err != nil {
+				return nil, err
+			}
+		}
+	}
 	mapping, _ := gossie.NewMapping(&Column{})
-	row, _ := mapping.Map(&Column{key, value[key].(string)})
-	return row
+	return mapping.Map(&Column{key, data})
 }
 
-func (cs *Cassandra) Create(key string, value map[string]interface{}) error {
-	row := valueToRow(key, value)
-	err := cs.Pool.Writer().Insert(cs.ColumnFamily, row).Run()
-	return err
+func cassandraError(err error) error {
+	if err == nil {
+		return nil
+	}
+	message := err.Error()
+	switch {
+	case strings.Contains(message, "TimedOutException"):
+		return classify(Timeout, err)
+	case strings.Contains(message, "UnavailableException"):
+		return classify(Temporary, err)
+	case strings.Contains(message, "NotFoundException"):
+		return classify(NotFound, err)
+	}
+	return classify(ServerError, err)
+}
+
+func (cs *Cassandra) insert(key string, value interface{}, expiry int) error {
+	row, err := valueToRow(key, value)
+	if err != nil {
+		return err
+	}
+	if expiry > 0 {
+		err = cs.writer().InsertTtl(cs.ColumnFamily, row, expiry).Run()
+	} else {
+		err = cs.writer().Insert(cs.ColumnFamily, row).Run()
+	}
+	return cassandraError(err)
 }
 
-func (cs *Cassandra) Read(key string) error {
-	_, err := cs.Pool.Reader().Cf(cs.ColumnFamily).Get([]byte(key))
+func (cs *Cassandra) Create(key string, value interface{}, expiry int) error {
+	err := cs.insert(key, value, expiry)
 	return err
 }
 
-func (cs *Cassandra) Update(key string, value map[string]interface{}) error {
-	row := valueToRow(key, value)
-	err := cs.Pool.Writer().Insert(cs.ColumnFamily, row).Run()
+func (cs *Cassandra) Read(key string) (interface{}, error) {
+	row, err := cs.reader().Cf(cs.ColumnFamily).Get([]byte(key))
+	if err != nil {
+		return nil, cassandraError(err)
+	}
+	if row == nil {
+		return nil, ErrNotFound
+	}
+	for _, column := range row.Columns {
+		if string(column.Name) == "Value" {
+			return decodeRaw(column.Value)
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (cs *Cassandra) Update(key string, value interface{}, expiry int) error {
+	err := cs.insert(key, value, expiry)
 	return err
 }
 
 func (cs *Cassandra) Delete(key string) error {
-	err := cs.Pool.Writer().Delete(cs.ColumnFamily, []byte(key)).Run()
-	return err
+	err := cs.writer().Delete(cs.ColumnFamily, []byte(key)).Run()
+	return cassandraError(err)
 }
 
-func (cb *Cassandra) Query(key string, args []interface{}) error {
-	return nil
+// Query is not supported by the Thrift driver, use the CQL driver instead.
+func (cs *Cassandra) Query(key string, args []interface{}) ([]map[string]interface{}, error) {
+	return nil, &Error{ServerError, fmt.Errorf("queries are not supported, use the CQL driver")}
 }
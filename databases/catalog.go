@@ -0,0 +1,387 @@
+package databases
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"text/template"
+)
+
+// A Catalog defines the named queries used by the N1QL workload. Every query
+// declares its parameters, drawn from the document model by workload
+// generators, and per-driver templates: a map/reduce view (Couchbase), a
+// N1QL statement (Tuq), a find, distinct or aggregation pipeline (MongoDB),
+// a CQL statement with bind arguments (CQL) and a SQL statement over JSONB
+// paths with bind arguments (PostgreSQL).
+//
+// View parameters and MongoDB templates are JSON values in which strings of
+// the form "@name" are replaced with query parameters. N1QL statements are Go
+// templates, parameters and the "bucket" and "limit" values are available as
+// {{.name}}; the json function formats values as N1QL literals. CQL and SQL
+// statements are templates with the "table" and "limit" values.
+type Catalog struct {
+	Limit   int
+	Queries []*QueryDefinition
+
+	byName map[string]*QueryDefinition
+}
+
+type QueryDefinition struct {
+	Name   string
+	Params []Param
+	View   *ViewQuery
+	N1QL   *N1QLQuery
+	Mongo  *MongoQuery
+	CQL    *CQLQuery
+	SQL    *SQLQuery
+	Expect *Expectation
+
+	statement    *template.Template
+	cqlStatement *template.Template
+	sqlStatement *template.Template
+}
+
+// A Param is generated from the document field named by Generator, optionally
+// multiplied by Scale.
+type Param struct {
+	Name      string
+	Generator string
+	Scale     float64
+}
+
+type ViewQuery struct {
+	Map    string
+	Reduce string
+	Params map[string]interface{}
+}
+
+// N1QLQuery is a statement template and the GSI index it relies on. An index
+// without keys is the primary index.
+type N1QLQuery struct {
+	Statement string
+	Index     GSIIndex
+}
+
+type GSIIndex struct {
+	Name string
+	Keys string
+}
+
+// MongoQuery is either a Find (with an optional projection), a Distinct field
+// or an aggregation Pipeline. Index lists the keys of the supporting index.
+type MongoQuery struct {
+	Find     map[string]interface{}
+	Select   map[string]interface{}
+	Distinct string
+	Pipeline []interface{}
+	Index    []string
+}
+
+// CQLQuery is a statement template with bind markers for Args, which may
+// refer to parameters. Index lists the columns that require secondary indexes.
+// Statements with ALLOW FILTERING scan the rows selected by the indexes, or
+// the whole table without them.
+type CQLQuery struct {
+	Statement string
+	Args      []interface{}
+	Index     []string
+}
+
+// SQLQuery is a statement template with $n bind markers for Args, which may
+// refer to parameters. Index lists the expressions that require indexes.
+type SQLQuery struct {
+	Statement string
+	Args      []interface{}
+	Index     []string
+}
+
+// Expectation describes how the result of a query is derived from the
+// documents matching Filter: plain rows, statistics (count, sum, min and max)
+// of Field or distinct values of Field.
+type Expectation struct {
+	Kind   string
+	Field  string
+	Filter []Condition
+}
+
+// A Condition compares a document field with a query parameter or a constant
+// Value using one of =, <, <=, > and >=.
+type Condition struct {
+	Field string
+	Op    string
+	Param string
+	Value interface{}
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(value interface{}) (string, error) {
+		encoded, err := json.Marshal(value)
+		return string(encoded), err
+	},
+}
+
+// queryCatalog returns the catalog passed by the caller or loads it.
+func (config Config) queryCatalog() *Catalog {
+	if config.QueryCatalog != nil {
+		return config.QueryCatalog
+	}
+	catalog, err := LoadCatalog(config.Catalog)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return catalog
+}
+
+// LoadCatalog reads and validates a catalog file. The built-in catalog is
+// used when path is empty.
+func LoadCatalog(path string) (*Catalog, error) {
+	data := []byte(DefaultCatalog)
+	if path != "" {
+		var err error
+		if data, err = ioutil.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	catalog := &Catalog{}
+	if err := json.Unmarshal(data, catalog); err != nil {
+		return nil, fmt.Errorf("query catalog: %v", err)
+	}
+	if err := catalog.init(); err != nil {
+		return nil, fmt.Errorf("query catalog: %v", err)
+	}
+	return catalog, nil
+}
+
+func (c *Catalog) init() error {
+	if c.Limit == 0 {
+		c.Limit = 20
+	}
+	c.byName = map[string]*QueryDefinition{}
+	for _, q := range c.Queries {
+		if q.Name == "" {
+			return fmt.Errorf("query without name")
+		}
+		if _, ok := c.byName[q.Name]; ok {
+			return fmt.Errorf("duplicate query: %s", q.Name)
+		}
+		c.byName[q.Name] = q
+		if err := q.init(); err != nil {
+			return fmt.Errorf("%s: %v", q.Name, err)
+		}
+	}
+	return nil
+}
+
+func (q *QueryDefinition) init() error {
+	names := map[string]bool{"limit": true}
+	for _, param := range q.Params {
+		if param.Name == "" || param.Generator == "" {
+			return fmt.Errorf("parameters require Name and Generator")
+		}
+		names[param.Name] = true
+	}
+	if q.View != nil {
+		if q.View.Map == "" {
+			return fmt.Errorf("view without map function")
+		}
+		if err := checkPlaceholders(q.View.Params, names); err != nil {
+			return err
+		}
+	}
+	if q.Mongo != nil {
+		for _, t := range []interface{}{q.Mongo.Find, q.Mongo.Pipeline} {
+			if err := checkPlaceholders(t, names); err != nil {
+				return err
+			}
+		}
+	}
+	if q.N1QL != nil {
+		var err error
+		if q.statement, err = parseStatement(q.Name, q.N1QL.Statement); err != nil {
+			return err
+		}
+	}
+	if q.CQL != nil {
+		if err := checkPlaceholders(q.CQL.Args, names); err != nil {
+			return err
+		}
+		var err error
+		if q.cqlStatement, err = parseStatement(q.Name, q.CQL.Statement); err != nil {
+			return err
+		}
+	}
+	if q.SQL != nil {
+		if err := checkPlaceholders(q.SQL.Args, names); err != nil {
+			return err
+		}
+		var err error
+		if q.sqlStatement, err = parseStatement(q.Name, q.SQL.Statement); err != nil {
+			return err
+		}
+	}
+	if e := q.Expect; e != nil {
+		switch e.Kind {
+		case "rows":
+		case "stats", "distinct":
+			if e.Field == "" {
+				return fmt.Errorf("%s expectation without field", e.Kind)
+			}
+		default:
+			return fmt.Errorf("unknown expectation: %s", e.Kind)
+		}
+		for _, condition := range e.Filter {
+			switch condition.Op {
+			case "=", "<", "<=", ">", ">=":
+			default:
+				return fmt.Errorf("unknown operator: %s", condition.Op)
+			}
+			if condition.Param != "" && !names[condition.Param] {
+				return fmt.Errorf("unknown parameter: %s", condition.Param)
+			}
+		}
+	}
+	return nil
+}
+
+func parseStatement(name, statement string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(statement)
+}
+
+func checkPlaceholders(t interface{}, names map[string]bool) error {
+	switch v := t.(type) {
+	case string:
+		if strings.HasPrefix(v, "@") && !names[v[1:]] {
+			return fmt.Errorf("unknown parameter: %s", v)
+		}
+	case map[string]interface{}:
+		for _, value := range v {
+			if err := checkPlaceholders(value, names); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, value := range v {
+			if err := checkPlaceholders(value, names); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Catalog) Get(name string) (*QueryDefinition, bool) {
+	q, ok := c.byName[name]
+	return q, ok
+}
+
+// Check verifies that all given queries are defined for the driver.
+func (c *Catalog) Check(driver string, names []string) error {
+	for _, name := range names {
+		q, ok := c.Get(name)
+		if !ok {
+			return fmt.Errorf("unknown query: %s", name)
+		}
+		supported := true
+		switch driver {
+		case "Couchbase":
+			supported = q.View != nil
+		case "Tuq":
+			supported = q.N1QL != nil
+		case "MongoDB":
+			supported = q.Mongo != nil
+		case "CQL":
+			supported = q.CQL != nil
+		case "PostgreSQL":
+			supported = q.SQL != nil
+		case "Redis":
+			supported = searchable(q)
+		case "Cassandra", "Bolt":
+			supported = false
+		}
+		if !supported {
+			return fmt.Errorf("query %s is not defined for %s", name, driver)
+		}
+	}
+	return nil
+}
+
+// Prepare looks up the query named by the first argument and maps the rest of
+// the arguments to its parameters.
+func (c *Catalog) Prepare(args []interface{}) (*QueryDefinition, map[string]interface{}, error) {
+	name, _ := args[0].(string)
+	q, ok := c.Get(name)
+	if !ok {
+		return nil, nil, &Error{ServerError, fmt.Errorf("unknown query: %s", name)}
+	}
+	if len(args)-1 != len(q.Params) {
+		return nil, nil, &Error{ServerError,
+			fmt.Errorf("%s expects %d parameters", name, len(q.Params))}
+	}
+	values := map[string]interface{}{"limit": c.Limit}
+	for i, param := range q.Params {
+		values[param.Name] = args[i+1]
+	}
+	return q, values, nil
+}
+
+// Render returns a copy of the template with "@name" placeholders replaced
+// by values.
+func Render(t interface{}, values map[string]interface{}) interface{} {
+	switch v := t.(type) {
+	case string:
+		if strings.HasPrefix(v, "@") {
+			if value, ok := values[v[1:]]; ok {
+				return value
+			}
+		}
+		return v
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			rendered[key] = Render(value, values)
+		}
+		return rendered
+	case []interface{}:
+		rendered := make([]interface{}, len(v))
+		for i, value := range v {
+			rendered[i] = Render(value, values)
+		}
+		return rendered
+	}
+	return t
+}
+
+// Statement executes the N1QL template.
+func (q *QueryDefinition) Statement(values map[string]interface{}) (string, error) {
+	return execute(q.statement, values)
+}
+
+// CQLStatement executes the CQL template and renders its bind arguments.
+func (q *QueryDefinition) CQLStatement(values map[string]interface{}) (string, []interface{}, error) {
+	statement, err := execute(q.cqlStatement, values)
+	if err != nil {
+		return "", nil, err
+	}
+	return statement, Render(q.CQL.Args, values).([]interface{}), nil
+}
+
+// SQLStatement executes the SQL template and renders its bind arguments.
+func (q *QueryDefinition) SQLStatement(values map[string]interface{}) (string, []interface{}, error) {
+	statement, err := execute(q.sqlStatement, values)
+	if err != nil {
+		return "", nil, err
+	}
+	args, _ := Render(q.SQL.Args, values).([]interface{})
+	return statement, args, nil
+}
+
+func execute(t *template.Template, data interface{}) (string, error) {
+	var statement bytes.Buffer
+	if err := t.Execute(&statement, data); err != nil {
+		return "", &Error{ServerError, err}
+	}
+	return statement.String(), nil
+}
@@ -0,0 +1,42 @@
+package databases
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCatalog(t *testing.T) {
+	catalog, err := LoadCatalog("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := catalog.Check("Tuq", []string{"calc_by_city"}); err == nil {
+		t.Error("calc_by_city is not defined for Tuq")
+	}
+
+	q, values, err := catalog.Prepare([]interface{}{"coins_stats_by_state_and_year", "CA", int16(1990)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := Render(q.View.Params, values)
+	expected := map[string]interface{}{"key": []interface{}{"CA", int16(1990)}, "group": true}
+	if !reflect.DeepEqual(params, expected) {
+		t.Errorf("%v != %v", params, expected)
+	}
+
+	values["bucket"] = "default"
+	statement, err := q.Statement(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedStatement := "SELECT COUNT(coins.f) AS count, SUM(coins.f) AS sum, AVG(coins.f) AS avg, " +
+		"MIN(coins.f) AS min, MAX(coins.f) AS max FROM default " +
+		"WHERE state.f = \"CA\" AND year = 1990 GROUP BY state.f, year LIMIT 20"
+	if statement != expectedStatement {
+		t.Errorf("%v != %v", statement, expectedStatement)
+	}
+
+	if _, _, err := catalog.Prepare([]interface{}{"distinct_years", "year"}); err == nil {
+		t.Error("extra parameters accepted")
+	}
+}
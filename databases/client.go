@@ -0,0 +1,157 @@
+package databases
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbaselabs/blurr/stats"
+)
+
+// ClientConfig tunes the client libraries of the drivers, zero values keep
+// driver defaults. Timeouts are in milliseconds, KeepAlive is the TCP
+// keep-alive period in seconds (negative disables keep-alives).
+type ClientConfig struct {
+	PoolSize       int
+	ConnectTimeout int
+	OpTimeout      int
+	KeepAlive      int
+	SessionReuse   string
+}
+
+// Session reuse policies: Pooled operations borrow connections from a pool,
+// Shared operations use a single session and None opens a connection per
+// operation.
+const (
+	PooledSessions = "Pooled"
+	SharedSessions = "Shared"
+	NoSessionReuse = "None"
+)
+
+func (c ClientConfig) connectTimeout(def time.Duration) time.Duration {
+	if c.ConnectTimeout > 0 {
+		return time.Duration(c.ConnectTimeout) * time.Millisecond
+	}
+	return def
+}
+
+func (c ClientConfig) opTimeout(def time.Duration) time.Duration {
+	if c.OpTimeout > 0 {
+		return time.Duration(c.OpTimeout) * time.Millisecond
+	}
+	return def
+}
+
+func (c ClientConfig) keepAlive() time.Duration {
+	return time.Duration(c.KeepAlive) * time.Second
+}
+
+func (c ClientConfig) poolSize(def int) int {
+	if c.PoolSize > 0 {
+		return c.PoolSize
+	}
+	return def
+}
+
+// sessionReuse validates the policy against the ones supported by the driver,
+// the first of which is the default.
+func (c ClientConfig) sessionReuse(driver string, supported ...string) string {
+	if c.SessionReuse == "" {
+		return supported[0]
+	}
+	for _, policy := range supported {
+		if c.SessionReuse == policy {
+			return policy
+		}
+	}
+	log.Fatalf("%s driver does not support session reuse policy %s", driver, c.SessionReuse)
+	return ""
+}
+
+// connStats counts connections opened and closed by a driver, so that pool
+// configurations can be compared by connection churn.
+type connStats struct {
+	Dialer      net.Dialer
+	Opened      int64
+	Closed      int64
+	Failed      int64
+	DialLatency stats.Histogram
+}
+
+func newConnStats(config ClientConfig) *connStats {
+	return &connStats{
+		Dialer: net.Dialer{
+			Timeout:   config.connectTimeout(5 * time.Second),
+			KeepAlive: config.keepAlive(),
+		},
+	}
+}
+
+type trackedConn struct {
+	net.Conn
+	stats  *connStats
+	closed int32
+}
+
+func (c *trackedConn) Close() error {
+	if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		atomic.AddInt64(&c.stats.Closed, 1)
+	}
+	return c.Conn.Close()
+}
+
+func (s *connStats) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	t0 := time.Now()
+	conn, err := s.Dialer.DialContext(ctx, network, address)
+	if err != nil {
+		atomic.AddInt64(&s.Failed, 1)
+		return nil, err
+	}
+	s.DialLatency.Record(float64(time.Since(t0)/time.Microsecond) / 1000)
+	atomic.AddInt64(&s.Opened, 1)
+	return &trackedConn{Conn: conn, stats: s}, nil
+}
+
+func (s *connStats) Dial(network, address string) (net.Conn, error) {
+	return s.DialContext(context.Background(), network, address)
+}
+
+func (s *connStats) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.DialContext(ctx, network, address)
+}
+
+// transport returns an HTTP transport that dials through s.
+func (s *connStats) transport(config ClientConfig, tlsConfig *tls.Config,
+	maxIdleConnsPerHost int) *http.Transport {
+	tr := &http.Transport{
+		DialContext:           s.DialContext,
+		TLSClientConfig:       tlsConfig,
+		MaxIdleConnsPerHost:   config.poolSize(maxIdleConnsPerHost),
+		ResponseHeaderTimeout: config.opTimeout(0),
+	}
+	switch config.SessionReuse {
+	case SharedSessions:
+		tr.MaxConnsPerHost = 1
+	case NoSessionReuse:
+		tr.DisableKeepAlives = true
+	}
+	return tr
+}
+
+func (s *connStats) ReportSummary() {
+	fmt.Println("Connections:")
+	fmt.Printf("\tOpened: %v\n", atomic.LoadInt64(&s.Opened))
+	fmt.Printf("\tClosed: %v\n", atomic.LoadInt64(&s.Closed))
+	fmt.Printf("\tFailed dials: %v\n", atomic.LoadInt64(&s.Failed))
+	if s.DialLatency.Total() > 0 {
+		fmt.Printf("\tDial latency: mean %.2f ms, 99th percentile %.2f ms\n",
+			s.DialLatency.Mean(), s.DialLatency.Percentile(0.99))
+	}
+}
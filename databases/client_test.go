@@ -0,0 +1,30 @@
+package databases
+
+import (
+	"net"
+	"testing"
+)
+
+func TestConnStats(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	conns := newConnStats(ClientConfig{ConnectTimeout: 100})
+	for i := 0; i < 2; i++ {
+		conn, err := conns.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+		conn.Close()
+	}
+	listener.Close()
+	conns.Dial("tcp", listener.Addr().String())
+
+	if conns.Opened != 2 || conns.Closed != 2 || conns.Failed != 1 || conns.DialLatency.Total() != 2 {
+		t.Errorf("unexpected connection stats: %+v", conns)
+	}
+}
@@ -1,100 +1,366 @@
 package databases
 
 import (
+	"fmt"
 	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/couchbaselabs/blurr/stats"
 	"github.com/couchbaselabs/go-couchbase"
+	"github.com/dustin/gomemcached"
 )
 
 type Couchbase struct {
-	Bucket *couchbase.Bucket
+	Bucket     *couchbase.Bucket
+	Catalog    *Catalog
+	durability DurabilityConfig
+	conns      *connStats
+	nodes      map[string]*nodeStats
+	nodesLock  sync.Mutex
 }
 
+// nodeStats are statistics of key-value operations by the node owning the
+// active vBucket of the key.
+type nodeStats struct {
+	Ops     int64
+	Errors  int64
+	Latency stats.Histogram
+}
+
+var staleValues = map[string]bool{"": true, "false": true, "ok": true, "update_after": true}
+
+// configureClient sets the connection pool of the client library, which is
+// shared by all buckets. Connection statistics cover HTTP (REST and view)
+// connections, memcached connections are dialed by the library.
+func (cb *Couchbase) configureClient(config ClientConfig) {
+	config.sessionReuse("Couchbase", PooledSessions, SharedSessions, NoSessionReuse)
+	cb.conns = newConnStats(config)
+	couchbase.PoolSize = config.poolSize(couchbase.PoolSize)
+	couchbase.HTTPClient = &http.Client{
+		Transport: cb.conns.transport(config, nil, MaxIdleConnsPerHost),
+		Timeout:   config.opTimeout(0),
+	}
+	if config.KeepAlive != 0 {
+		couchbase.TCPKeepalive = config.KeepAlive > 0
+		couchbase.TCPKeepaliveInterval = config.KeepAlive
+	}
+}
+
+// Init authenticates with RBAC credentials when Username is set, they are
+// passed as user info of the REST address. TLS is not supported: memcached
+// connections are dialed in plain text by the client library.
 func (cb *Couchbase) Init(config Config) {
-	address := strings.Replace(config.Addresses[0], "8093", "8091", -1)
-	bucket, err := couchbase.GetBucket(address, config.Name, config.Table)
+	if config.TLS.Enabled {
+		log.Fatal("Couchbase driver does not support TLS")
+	}
+	cb.configureClient(config.Client)
+	bucket, err := cb.bootstrap(config)
 	if err != nil {
 		log.Fatal(err)
 	}
 	cb.Bucket = bucket
+	cb.nodes = map[string]*nodeStats{}
+
+	cb.durability = config.Durability
+	if !staleValues[cb.durability.Read] {
+		log.Fatalf("Unknown view consistency: %s", cb.durability.Read)
+	}
+	if cb.durability.PersistTo > 0 || cb.durability.ReplicateTo > 0 {
+		err = bucket.SetObserveAndPersist(couchbase.PersistTo(cb.durability.PersistTo),
+			couchbase.ObserveTo(cb.durability.ReplicateTo))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	cb.Catalog = config.queryCatalog()
+}
+
+// bootstrap connects to the first available node of the list, starting with a
+// random one so that clients spread the load. The client library follows
+// topology changes once connected.
+func (cb *Couchbase) bootstrap(config Config) (*couchbase.Bucket, error) {
+	offset := rand.Intn(len(config.Addresses))
+	var err error
+	for i := range config.Addresses {
+		address := config.Addresses[(offset+i)%len(config.Addresses)]
+		var u *url.URL
+		if u, err = url.Parse(strings.Replace(address, "8093", "8091", -1)); err != nil {
+			return nil, err
+		}
+		if config.Username != "" {
+			u.User = url.UserPassword(config.Username, config.Password)
+		}
+		var bucket *couchbase.Bucket
+		if bucket, err = couchbase.GetBucket(u.String(), config.Name, config.Table); err == nil {
+			return bucket, nil
+		}
+		log.Printf("Failed to bootstrap from %s: %v", address, err)
+	}
+	return nil, err
 }
 
 func (cb *Couchbase) Shutdown() {
 	cb.Bucket.Close()
 }
 
-func (cb *Couchbase) Create(key string, value map[string]interface{}) error {
-	err := cb.Bucket.Set(key, 0, value)
-	return err
+func (cb *Couchbase) ReportSummary() {
+	cb.conns.ReportSummary()
+	cb.reportNodes()
 }
 
-func (cb *Couchbase) Read(key string) error {
-	result := map[string]interface{}{}
-	err := cb.Bucket.Get(key, &result)
-	return err
+// node returns the address of the node owning the active vBucket of the key.
+func (cb *Couchbase) node(key string) string {
+	return vbucketNode(cb.Bucket.VBServerMap(), int(cb.Bucket.VBHash(key)))
+}
+
+func vbucketNode(vbm *couchbase.VBucketServerMap, vb int) string {
+	if vb < len(vbm.VBucketMap) && len(vbm.VBucketMap[vb]) > 0 {
+		if server := vbm.VBucketMap[vb][0]; server >= 0 && server < len(vbm.ServerList) {
+			return vbm.ServerList[server]
+		}
+	}
+	return "unknown"
+}
+
+// nodeErrors are the error classes that count as errors of a node, other
+// classes are outcomes of the workload (e.g. reads of deleted keys).
+var nodeErrors = map[ErrorClass]bool{
+	Timeout:     true,
+	Temporary:   true,
+	Connection:  true,
+	ServerError: true,
 }
 
-func (cb *Couchbase) Update(key string, value map[string]interface{}) error {
-	err := cb.Bucket.Set(key, 0, value)
+// track runs a key-value operation and records it in the stats of the node.
+func (cb *Couchbase) track(key string, op func() error) error {
+	node := cb.node(key)
+	t0 := time.Now()
+	err := op()
+	cb.record(node, float64(time.Since(t0)/time.Microsecond)/1000, err)
 	return err
 }
 
+func (cb *Couchbase) record(node string, latency float64, err error) {
+	cb.nodesLock.Lock()
+	s, ok := cb.nodes[node]
+	if !ok {
+		s = &nodeStats{}
+		cb.nodes[node] = s
+	}
+	cb.nodesLock.Unlock()
+
+	s.Latency.Record(latency)
+	atomic.AddInt64(&s.Ops, 1)
+	if err != nil && nodeErrors[ClassOf(couchbaseError(err))] {
+		atomic.AddInt64(&s.Errors, 1)
+	}
+}
+
+func (cb *Couchbase) reportNodes() {
+	cb.nodesLock.Lock()
+	defer cb.nodesLock.Unlock()
+	if len(cb.nodes) == 0 {
+		return
+	}
+	nodes := make([]string, 0, len(cb.nodes))
+	for node := range cb.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	fmt.Println("Nodes:")
+	for _, node := range nodes {
+		s := cb.nodes[node]
+		fmt.Printf("\t%s:\n", node)
+		fmt.Printf("\t\tOperations: %v (errors: %v)\n", s.Ops, s.Errors)
+		fmt.Printf("\t\tLatency: mean %.2f ms, 99th percentile %.2f ms\n",
+			s.Latency.Mean(), s.Latency.Percentile(0.99))
+	}
+}
+
+func (cb *Couchbase) writeDurability() string {
+	return fmt.Sprintf("writes: persist to %d, replicate to %d",
+		cb.durability.PersistTo, cb.durability.ReplicateTo)
+}
+
+func (cb *Couchbase) Durability() string {
+	stale := cb.durability.Read
+	if stale == "" {
+		stale = "update_after"
+	}
+	return fmt.Sprintf("%s; view reads: stale=%s", cb.writeDurability(), stale)
+}
+
+// maxRelativeExpiry is the largest TTL memcached treats as relative, larger
+// values are read as Unix timestamps.
+const maxRelativeExpiry = 30 * 24 * 60 * 60
+
+func memcachedExpiry(ttl int, now time.Time) int {
+	if ttl > maxRelativeExpiry {
+		return int(now.Unix()) + ttl
+	}
+	return ttl
+}
+
+func (cb *Couchbase) set(key string, value interface{}, expiry int) error {
+	expiry = memcachedExpiry(expiry, time.Now())
+	if raw, ok := value.([]byte); ok {
+		return cb.Bucket.SetRaw(key, expiry, raw)
+	}
+	return cb.Bucket.Set(key, expiry, value)
+}
+
+func couchbaseError(err error) error {
+	res, ok := err.(*gomemcached.MCResponse)
+	if !ok {
+		return classify(ServerError, err)
+	}
+	switch res.Status {
+	case gomemcached.KEY_ENOENT:
+		return classify(NotFound, err)
+	case gomemcached.KEY_EEXISTS:
+		return classify(CASConflict, err)
+	case gomemcached.NOT_STORED:
+		return classify(AlreadyExists, err)
+	case gomemcached.TMPFAIL, gomemcached.ENOMEM, gomemcached.NOT_MY_VBUCKET:
+		return classify(Temporary, err)
+	}
+	return classify(ServerError, err)
+}
+
+func (cb *Couchbase) Create(key string, value interface{}, expiry int) error {
+	err := cb.track(key, func() error { return cb.set(key, value, expiry) })
+	return couchbaseError(err)
+}
+
+func (cb *Couchbase) Read(key string) (interface{}, error) {
+	var raw []byte
+	err := cb.track(key, func() (err error) {
+		raw, err = cb.Bucket.GetRaw(key)
+		return err
+	})
+	if err != nil {
+		return nil, couchbaseError(err)
+	}
+	return decodeRaw(raw)
+}
+
+func (cb *Couchbase) Update(key string, value interface{}, expiry int) error {
+	err := cb.track(key, func() error { return cb.set(key, value, expiry) })
+	return couchbaseError(err)
+}
+
 func (cb *Couchbase) Delete(key string) error {
-	err := cb.Bucket.Delete(key)
-	return err
+	err := cb.track(key, func() error { return cb.Bucket.Delete(key) })
+	return couchbaseError(err)
 }
 
 var DDOC_NAME = "ddoc"
 
-func (cb *Couchbase) Query(key string, args []interface{}) error {
-	index := args[0].(string)
-	params := map[string]interface{}{"limit": 20}
-
-	switch index {
-	case "name_and_street_by_city":
-		params["key"] = args[1]
-	case "name_and_email_by_county":
-		params["key"] = args[1]
-	case "achievements_by_realm":
-		params["key"] = args[1]
-	case "name_by_coins":
-		params["startkey"] = args[1].(float64) * 0.5
-		params["endkey"] = args[1]
-	case "email_by_achievement_and_category":
-		params["startkey"] = []interface{}{0, args[2]}
-		params["endkey"] = []interface{}{args[1].([]int16)[0], args[2]}
-	case "street_by_year_and_coins":
-		params["startkey"] = []interface{}{args[1], args[2]}
-		params["endkey"] = []interface{}{args[1], 655.35}
-	case "coins_stats_by_state_and_year":
-		params["key"] = []interface{}{args[1], args[2]}
-		params["group"] = true
-	case "coins_stats_by_gmtime_and_year":
-		params["key"] = []interface{}{args[1], args[2]}
-		params["group_level"] = 2
-	case "coins_stats_by_full_state_and_year":
-		params["key"] = []interface{}{args[1], args[2]}
-		params["group"] = true
-	case "name_and_email_and_street_and_achievements_and_coins_by_city":
-		params["key"] = args[1]
-	case "street_and_name_and_email_and_achievement_and_coins_by_county":
-		params["key"] = args[1]
-	case "category_name_and_email_and_street_and_gmtime_and_year_by_country":
-		params["key"] = args[1]
-	case "calc_by_city":
-		params["key"] = args[1]
-	case "calc_by_county":
-		params["key"] = args[1]
-	case "calc_by_realm":
-		params["key"] = args[1]
-	case "body_by_city":
-		params["key"] = args[1]
-	case "body_by_realm":
-		params["key"] = args[1]
-	case "body_by_country":
-		params["key"] = args[1]
-	}
-	_, err := cb.Bucket.View(DDOC_NAME, index, params)
-	return err
+func (cb *Couchbase) Query(key string, args []interface{}) ([]map[string]interface{}, error) {
+	q, values, err := cb.Catalog.Prepare(args)
+	if err != nil {
+		return nil, err
+	}
+	if q.View == nil {
+		return nil, &Error{ServerError, fmt.Errorf("no view for query %s", q.Name)}
+	}
+	params := Render(q.View.Params, values).(map[string]interface{})
+	if _, ok := params["limit"]; !ok {
+		params["limit"] = cb.Catalog.Limit
+	}
+	if cb.durability.Read != "" {
+		params["stale"] = cb.durability.Read
+	}
+
+	result, err := cb.Bucket.View(DDOC_NAME, q.Name, params)
+	if err != nil {
+		return nil, couchbaseError(err)
+	}
+	if len(result.Errors) > 0 {
+		return nil, &Error{ServerError, fmt.Errorf("view error from %s: %s",
+			result.Errors[0].From, result.Errors[0].Reason)}
+	}
+	return viewRows(result), nil
+}
+
+// viewRows converts view rows to result rows. Reduced rows with object values
+// (e.g. _stats) are returned as these objects.
+func viewRows(result couchbase.ViewResult) []map[string]interface{} {
+	rows := make([]map[string]interface{}, 0, len(result.Rows))
+	for _, row := range result.Rows {
+		if value, ok := row.Value.(map[string]interface{}); ok && row.ID == "" {
+			rows = append(rows, value)
+		} else {
+			rows = append(rows, map[string]interface{}{
+				"id":    row.ID,
+				"key":   row.Key,
+				"value": row.Value,
+			})
+		}
+	}
+	return rows
+}
+
+type view struct {
+	Map    string `json:"map"`
+	Reduce string `json:"reduce,omitempty"`
+}
+
+// Setup creates the design document with views for the given indexes.
+func (cb *Couchbase) Setup(indexes []string) error {
+	if err := cb.Catalog.Check("Couchbase", indexes); err != nil {
+		return err
+	}
+	views := map[string]view{}
+	for _, index := range indexes {
+		q, _ := cb.Catalog.Get(index)
+		views[index] = view{Map: q.View.Map, Reduce: q.View.Reduce}
+	}
+	ddoc := map[string]interface{}{"views": views}
+	return cb.Bucket.PutDDoc(DDOC_NAME, ddoc)
+}
+
+// Teardown deletes the design document if it exists.
+func (cb *Couchbase) Teardown(indexes []string) error {
+	return ignoreSchemaError(cb.Bucket.DeleteDDoc(DDOC_NAME), "not_found")
+}
+
+// Scan reads document keys in key order from the _all_docs index. View
+// queries have no cursors, so cursor pagination is not supported.
+func (cb *Couchbase) Scan(startKey string, options ScanOptions) (int, error) {
+	if options.Pagination == CursorPagination {
+		return 0, &Error{ServerError, fmt.Errorf("cursor pagination is not supported")}
+	}
+	params := map[string]interface{}{"startkey": startKey}
+	scanned := 0
+	for scanned < options.Length {
+		limit := options.pageLimit(scanned)
+		params["limit"] = limit
+		if options.Pagination != KeysetPagination {
+			params["skip"] = scanned
+		}
+		result, err := cb.Bucket.View("", "_all_docs", params)
+		if err != nil {
+			return scanned, couchbaseError(err)
+		}
+		scanned += len(result.Rows)
+		if len(result.Rows) < limit {
+			break
+		}
+		if options.Pagination == KeysetPagination {
+			last := result.Rows[len(result.Rows)-1]
+			params["startkey"] = last.ID
+			params["startkey_docid"] = last.ID
+			params["skip"] = 1
+		}
+	}
+	return scanned, nil
 }
@@ -0,0 +1,59 @@
+package databases
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/couchbaselabs/go-couchbase"
+	"github.com/dustin/gomemcached"
+)
+
+func TestMemcachedExpiry(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	for ttl, expected := range map[int]int{
+		0:                     0,
+		60:                    60,
+		maxRelativeExpiry:     maxRelativeExpiry,
+		maxRelativeExpiry + 1: 1500000000 + maxRelativeExpiry + 1,
+	} {
+		if expiry := memcachedExpiry(ttl, now); expiry != expected {
+			t.Errorf("%d: %d != %d", ttl, expiry, expected)
+		}
+	}
+}
+
+func TestVBucketNode(t *testing.T) {
+	vbm := &couchbase.VBucketServerMap{
+		ServerList: []string{"node1:11210", "node2:11210"},
+		VBucketMap: [][]int{{0, 1}, {1, 0}, {-1, 0}, {}},
+	}
+	for vb, expected := range map[int]string{
+		0: "node1:11210", 1: "node2:11210", 2: "unknown", 3: "unknown", 4: "unknown",
+	} {
+		if node := vbucketNode(vbm, vb); node != expected {
+			t.Errorf("vBucket %d: %s != %s", vb, node, expected)
+		}
+	}
+}
+
+func TestNodeStats(t *testing.T) {
+	cb := &Couchbase{nodes: map[string]*nodeStats{}}
+	for _, err := range []error{
+		nil,
+		&gomemcached.MCResponse{Status: gomemcached.KEY_ENOENT},
+		&gomemcached.MCResponse{Status: gomemcached.KEY_EEXISTS},
+		&gomemcached.MCResponse{Status: gomemcached.TMPFAIL},
+		errors.New("connection reset"),
+	} {
+		cb.record("node1:11210", 1, err)
+	}
+	cb.record("node2:11210", 2, nil)
+
+	if s := cb.nodes["node1:11210"]; s.Ops != 5 || s.Errors != 2 || s.Latency.Total() != 5 {
+		t.Errorf("node1: unexpected stats: %+v", s)
+	}
+	if s := cb.nodes["node2:11210"]; s.Ops != 1 || s.Errors != 0 {
+		t.Errorf("node2: unexpected stats: %+v", s)
+	}
+}
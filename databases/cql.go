@@ -0,0 +1,368 @@
+package databases
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+type CQLConfig struct {
+	WriteConsistency  string
+	ReadConsistency   string
+	ReplicationFactor int
+}
+
+// CQL is a Cassandra driver using the native protocol. Documents are stored as
+// JSON (or raw bytes) in the value column, fields of N1QL workload documents
+// are also stored in typed columns so that they can be queried.
+type CQL struct {
+	Session          *gocql.Session
+	Keyspace         string
+	Table            string
+	Catalog          *Catalog
+	WriteConsistency gocql.Consistency
+	ReadConsistency  gocql.Consistency
+	config           CQLConfig
+	conns            *connStats
+}
+
+func (c *CQL) Durability() string {
+	return fmt.Sprintf("writes: %s; reads: %s", consistencyName(c.WriteConsistency),
+		consistencyName(c.ReadConsistency))
+}
+
+var consistencyLevels = map[string]gocql.Consistency{
+	"ANY":          gocql.Any,
+	"ONE":          gocql.One,
+	"TWO":          gocql.Two,
+	"THREE":        gocql.Three,
+	"QUORUM":       gocql.Quorum,
+	"ALL":          gocql.All,
+	"LOCAL_QUORUM": gocql.LocalQuorum,
+	"EACH_QUORUM":  gocql.EachQuorum,
+	"LOCAL_ONE":    gocql.LocalOne,
+}
+
+func consistencyName(consistency gocql.Consistency) string {
+	for name, level := range consistencyLevels {
+		if level == consistency {
+			return name
+		}
+	}
+	return "UNKNOWN"
+}
+
+func parseConsistency(level string) gocql.Consistency {
+	if level == "" {
+		return gocql.Quorum
+	}
+	consistency, ok := consistencyLevels[strings.ToUpper(level)]
+	if !ok {
+		log.Fatalf("Unknown consistency level: %s", level)
+	}
+	return consistency
+}
+
+// The session is not bound to the keyspace, so that Setup can create it.
+func (c *CQL) Init(config Config) {
+	c.config = config.CQL
+	c.Keyspace = config.Name
+	c.Table = config.Table
+	write, read := config.CQL.WriteConsistency, config.CQL.ReadConsistency
+	if config.Durability.Write != "" {
+		write = config.Durability.Write
+	}
+	if config.Durability.Read != "" {
+		read = config.Durability.Read
+	}
+	c.WriteConsistency = parseConsistency(write)
+	c.ReadConsistency = parseConsistency(read)
+
+	config.Client.sessionReuse("CQL", PooledSessions)
+	c.conns = newConnStats(config.Client)
+	cluster := gocql.NewCluster(config.Addresses...)
+	cluster.Consistency = c.ReadConsistency
+	cluster.Timeout = config.Client.opTimeout(5 * time.Second)
+	cluster.ConnectTimeout = config.Client.connectTimeout(5 * time.Second)
+	cluster.NumConns = config.Client.poolSize(2)
+	if config.Client.KeepAlive > 0 {
+		cluster.SocketKeepalive = config.Client.keepAlive()
+	}
+	cluster.Dialer = c.conns
+	if config.Username != "" {
+		cluster.Authenticator = gocql.PasswordAuthenticator{
+			Username: config.Username,
+			Password: config.Password,
+		}
+	}
+	tlsConfig, err := config.TLS.config()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if tlsConfig != nil {
+		cluster.SslOpts = &gocql.SslOptions{
+			Config:                 tlsConfig,
+			EnableHostVerification: !config.TLS.SkipVerify,
+		}
+	}
+	cluster.PoolConfig.HostSelectionPolicy = gocql.TokenAwareHostPolicy(gocql.RoundRobinHostPolicy())
+
+	if c.Session, err = cluster.CreateSession(); err != nil {
+		log.Fatal(err)
+	}
+	c.Catalog = config.queryCatalog()
+}
+
+func (c *CQL) Shutdown() {
+	c.Session.Close()
+}
+
+func (c *CQL) ReportSummary() {
+	c.conns.ReportSummary()
+}
+
+func (c *CQL) table() string {
+	return c.Keyspace + "." + c.Table
+}
+
+func cqlError(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch err.(type) {
+	case *gocql.RequestErrWriteTimeout, *gocql.RequestErrReadTimeout:
+		return classify(Timeout, err)
+	case *gocql.RequestErrUnavailable:
+		return classify(Temporary, err)
+	}
+	switch err {
+	case gocql.ErrNotFound:
+		return classify(NotFound, err)
+	case gocql.ErrTimeoutNoResponse:
+		return classify(Timeout, err)
+	case gocql.ErrNoConnections:
+		return classify(Connection, err)
+	}
+	return classify(ServerError, err)
+}
+
+type cqlColumn struct {
+	name string
+	path []string
+}
+
+// cqlColumns maps typed columns to fields of N1QL workload documents.
+var cqlColumns = []cqlColumn{
+	{"name", []string{"name", "f", "f", "f"}},
+	{"email", []string{"email", "f", "f"}},
+	{"street", []string{"street", "f", "f"}},
+	{"city", []string{"city", "f", "f"}},
+	{"county", []string{"county", "f", "f"}},
+	{"realm", []string{"realm", "f"}},
+	{"country", []string{"country", "f"}},
+	{"state", []string{"state", "f"}},
+	{"full_state", []string{"full_state", "f"}},
+	{"coins", []string{"coins", "f"}},
+	{"category", []string{"category"}},
+	{"year", []string{"year"}},
+	{"achievements", []string{"achievements"}},
+	{"gmtime", []string{"gmtime"}},
+}
+
+const cqlSchema = `
+	CREATE TABLE IF NOT EXISTS %s (
+		key text PRIMARY KEY,
+		value blob,
+		name text,
+		email text,
+		street text,
+		city text,
+		county text,
+		realm text,
+		country text,
+		state text,
+		full_state text,
+		coins double,
+		category int,
+		year int,
+		achievements list<int>,
+		achievement int,
+		gmtime frozen<list<int>>
+	)`
+
+func lookup(doc map[string]interface{}, path []string) (interface{}, bool) {
+	var value interface{} = doc
+	for _, field := range path {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = m[field]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// typedColumns appends names and values of the typed columns of a document.
+func typedColumns(doc map[string]interface{}, names []string, values []interface{}) ([]string, []interface{}) {
+	for _, column := range cqlColumns {
+		if field, ok := lookup(doc, column.path); ok {
+			// Whole coins of remote documents are decoded as int64.
+			if coins, ok := field.(int64); ok && column.name == "coins" {
+				field = float64(coins)
+			}
+			names = append(names, column.name)
+			values = append(values, field)
+		}
+	}
+	var achievement interface{}
+	switch achievements := doc["achievements"].(type) {
+	case []int16:
+		achievement = int16(0)
+		if len(achievements) > 0 {
+			achievement = achievements[0]
+		}
+	case []interface{}:
+		achievement = int64(0)
+		if len(achievements) > 0 {
+			achievement = achievements[0]
+		}
+	}
+	if achievement != nil {
+		names = append(names, "achievement")
+		values = append(values, achievement)
+	}
+	return names, values
+}
+
+// columns returns names and values of the columns for a value.
+func columns(key string, value interface{}) ([]string, []interface{}, error) {
+	names := []string{"key", "value"}
+	if raw, ok := value.([]byte); ok {
+		return names, []interface{}{key, raw}, nil
+	}
+	doc := value.(map[string]interface{})
+	encoded, err := json.Marshal(doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	names, values := typedColumns(doc, names, []interface{}{key, encoded})
+	return names, values, nil
+}
+
+func (c *CQL) insert(key string, value interface{}, expiry int) error {
+	names, values, err := columns(key, value)
+	if err != nil {
+		return classify(ServerError, err)
+	}
+	statement := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", c.table(),
+		strings.Join(names, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(names)), ", "))
+	if expiry > 0 {
+		statement += " USING TTL ?"
+		values = append(values, expiry)
+	}
+	err = c.Session.Query(statement, values...).Consistency(c.WriteConsistency).Exec()
+	return cqlError(err)
+}
+
+func (c *CQL) Create(key string, value interface{}, expiry int) error {
+	return c.insert(key, value, expiry)
+}
+
+func (c *CQL) Read(key string) (interface{}, error) {
+	var raw []byte
+	statement := fmt.Sprintf("SELECT value FROM %s WHERE key = ?", c.table())
+	err := c.Session.Query(statement, key).Consistency(c.ReadConsistency).Scan(&raw)
+	if err != nil {
+		return nil, cqlError(err)
+	}
+	return decodeRaw(raw)
+}
+
+func (c *CQL) Update(key string, value interface{}, expiry int) error {
+	return c.insert(key, value, expiry)
+}
+
+func (c *CQL) Delete(key string) error {
+	statement := fmt.Sprintf("DELETE FROM %s WHERE key = ?", c.table())
+	err := c.Session.Query(statement, key).Consistency(c.WriteConsistency).Exec()
+	return cqlError(err)
+}
+
+// Query runs the CQL statement of the catalog query. Statements are prepared
+// by the driver on first use.
+func (c *CQL) Query(key string, args []interface{}) ([]map[string]interface{}, error) {
+	q, values, err := c.Catalog.Prepare(args)
+	if err != nil {
+		return nil, err
+	}
+	if q.CQL == nil {
+		return nil, &Error{ServerError, fmt.Errorf("no CQL statement for query %s", q.Name)}
+	}
+	values["table"] = c.table()
+	statement, bind, err := q.CQLStatement(values)
+	if err != nil {
+		return nil, err
+	}
+
+	iter := c.Session.Query(statement, bind...).Consistency(c.ReadConsistency).Iter()
+	rows := []map[string]interface{}{}
+	for {
+		row := map[string]interface{}{}
+		if !iter.MapScan(row) {
+			break
+		}
+		rows = append(rows, row)
+	}
+	return rows, cqlError(iter.Close())
+}
+
+// Setup creates the keyspace, the table and secondary indexes on the columns
+// used by the given queries.
+func (c *CQL) Setup(indexes []string) error {
+	if err := c.Catalog.Check("CQL", indexes); err != nil {
+		return err
+	}
+	replicationFactor := c.config.ReplicationFactor
+	if replicationFactor == 0 {
+		replicationFactor = 1
+	}
+	statements := []string{
+		fmt.Sprintf("CREATE KEYSPACE IF NOT EXISTS %s WITH replication = "+
+			"{'class': 'SimpleStrategy', 'replication_factor': %d}", c.Keyspace, replicationFactor),
+		fmt.Sprintf(cqlSchema, c.table()),
+	}
+	seen := map[string]bool{}
+	for _, index := range indexes {
+		q, _ := c.Catalog.Get(index)
+		for _, column := range q.CQL.Index {
+			if seen[column] {
+				continue
+			}
+			seen[column] = true
+			target := column
+			if column == "gmtime" {
+				target = "FULL(gmtime)"
+			}
+			statements = append(statements, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_%s ON %s (%s)",
+				c.Table, column, c.table(), target))
+		}
+	}
+	for _, statement := range statements {
+		if err := c.Session.Query(statement).Consistency(gocql.All).Exec(); err != nil {
+			return fmt.Errorf("%s: %v", statement, err)
+		}
+	}
+	return nil
+}
+
+// Teardown drops the table with its indexes.
+func (c *CQL) Teardown(indexes []string) error {
+	statement := fmt.Sprintf("DROP TABLE IF EXISTS %s", c.table())
+	return c.Session.Query(statement).Consistency(gocql.All).Exec()
+}
@@ -0,0 +1,30 @@
+package databases
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestColumns(t *testing.T) {
+	doc := map[string]interface{}{
+		"city":         map[string]interface{}{"f": map[string]interface{}{"f": "Pisa"}},
+		"year":         int16(1990),
+		"achievements": []int16{12, 40},
+	}
+	names, values, err := columns("key", doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"key", "value", "city", "year", "achievements", "achievement"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("%v != %v", names, expected)
+	}
+	if values[2] != "Pisa" || values[5] != int16(12) {
+		t.Errorf("unexpected values: %v", values)
+	}
+
+	names, _, _ = columns("key", []byte("payload"))
+	if len(names) != 2 {
+		t.Errorf("unexpected columns of binary value: %v", names)
+	}
+}
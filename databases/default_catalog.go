@@ -0,0 +1,1293 @@
+package databases
+
+// DefaultCatalog defines the queries of the N1QL workload. It is used unless
+// Database.Catalog points to a catalog file.
+const DefaultCatalog = `{
+    "Limit": 20,
+    "Queries": [
+        {
+            "Name": "name_and_street_by_city",
+            "Params": [
+                {
+                    "Name": "city",
+                    "Generator": "city"
+                }
+            ],
+            "View": {
+                "Map": "function (doc, meta) { if (doc.city && doc.city.f) { emit(doc.city.f.f, [doc.name.f.f.f, doc.street.f.f]); } }",
+                "Params": {
+                    "key": "@city"
+                }
+            },
+            "N1QL": {
+                "Statement": "SELECT name.f.f.f AS _name, street.f.f AS _street FROM {{.bucket}} WHERE city.f.f = {{json .city}} LIMIT {{.limit}}",
+                "Index": {
+                    "Name": "by_city",
+                    "Keys": "city.f.f"
+                }
+            },
+            "Mongo": {
+                "Find": {
+                    "city.f.f": "@city"
+                },
+                "Select": {
+                    "name.f.f.f": 1,
+                    "street.f.f": 1
+                },
+                "Index": [
+                    "city.f.f"
+                ]
+            },
+            "CQL": {
+                "Statement": "SELECT name, street FROM {{.table}} WHERE city = ? LIMIT {{.limit}}",
+                "Args": [
+                    "@city"
+                ],
+                "Index": [
+                    "city"
+                ]
+            },
+            "SQL": {
+                "Statement": "SELECT doc->'name'->'f'->'f'->>'f' AS _name, doc->'street'->'f'->>'f' AS _street FROM {{.table}} WHERE doc->'city'->'f'->>'f' = $1 LIMIT {{.limit}}",
+                "Args": [
+                    "@city"
+                ],
+                "Index": [
+                    "doc->'city'->'f'->>'f'"
+                ]
+            },
+            "Expect": {
+                "Kind": "rows",
+                "Filter": [
+                    {
+                        "Field": "city",
+                        "Op": "=",
+                        "Param": "city"
+                    }
+                ]
+            }
+        },
+        {
+            "Name": "name_and_email_by_county",
+            "Params": [
+                {
+                    "Name": "county",
+                    "Generator": "county"
+                }
+            ],
+            "View": {
+                "Map": "function (doc, meta) { if (doc.county && doc.county.f) { emit(doc.county.f.f, [doc.name.f.f.f, doc.email.f.f]); } }",
+                "Params": {
+                    "key": "@county"
+                }
+            },
+            "N1QL": {
+                "Statement": "SELECT name.f.f.f AS _name, email.f.f AS _email FROM {{.bucket}} WHERE county.f.f = {{json .county}} LIMIT {{.limit}}",
+                "Index": {
+                    "Name": "by_county",
+                    "Keys": "county.f.f"
+                }
+            },
+            "Mongo": {
+                "Find": {
+                    "county.f.f": "@county"
+                },
+                "Select": {
+                    "name.f.f.f": 1,
+                    "email.f.f": 1
+                },
+                "Index": [
+                    "county.f.f"
+                ]
+            },
+            "CQL": {
+                "Statement": "SELECT name, email FROM {{.table}} WHERE county = ? LIMIT {{.limit}}",
+                "Args": [
+                    "@county"
+                ],
+                "Index": [
+                    "county"
+                ]
+            },
+            "SQL": {
+                "Statement": "SELECT doc->'name'->'f'->'f'->>'f' AS _name, doc->'email'->'f'->>'f' AS _email FROM {{.table}} WHERE doc->'county'->'f'->>'f' = $1 LIMIT {{.limit}}",
+                "Args": [
+                    "@county"
+                ],
+                "Index": [
+                    "doc->'county'->'f'->>'f'"
+                ]
+            },
+            "Expect": {
+                "Kind": "rows",
+                "Filter": [
+                    {
+                        "Field": "county",
+                        "Op": "=",
+                        "Param": "county"
+                    }
+                ]
+            }
+        },
+        {
+            "Name": "achievements_by_realm",
+            "Params": [
+                {
+                    "Name": "realm",
+                    "Generator": "realm"
+                }
+            ],
+            "View": {
+                "Map": "function (doc, meta) { if (doc.realm) { emit(doc.realm.f, doc.achievements); } }",
+                "Params": {
+                    "key": "@realm"
+                }
+            },
+            "N1QL": {
+                "Statement": "SELECT achievements FROM {{.bucket}} WHERE realm.f = {{json .realm}} LIMIT {{.limit}}",
+                "Index": {
+                    "Name": "by_realm",
+                    "Keys": "realm.f"
+                }
+            },
+            "Mongo": {
+                "Find": {
+                    "realm.f": "@realm"
+                },
+                "Select": {
+                    "achievements": 1
+                },
+                "Index": [
+                    "realm.f"
+                ]
+            },
+            "CQL": {
+                "Statement": "SELECT achievements FROM {{.table}} WHERE realm = ? LIMIT {{.limit}}",
+                "Args": [
+                    "@realm"
+                ],
+                "Index": [
+                    "realm"
+                ]
+            },
+            "SQL": {
+                "Statement": "SELECT doc->'achievements' AS achievements FROM {{.table}} WHERE doc->'realm'->>'f' = $1 LIMIT {{.limit}}",
+                "Args": [
+                    "@realm"
+                ],
+                "Index": [
+                    "doc->'realm'->>'f'"
+                ]
+            },
+            "Expect": {
+                "Kind": "rows",
+                "Filter": [
+                    {
+                        "Field": "realm",
+                        "Op": "=",
+                        "Param": "realm"
+                    }
+                ]
+            }
+        },
+        {
+            "Name": "name_by_coins",
+            "Params": [
+                {
+                    "Name": "low",
+                    "Generator": "coins",
+                    "Scale": 0.5
+                },
+                {
+                    "Name": "high",
+                    "Generator": "coins"
+                }
+            ],
+            "View": {
+                "Map": "function (doc, meta) { if (doc.coins) { emit(doc.coins.f, doc.name.f.f.f); } }",
+                "Params": {
+                    "startkey": "@low",
+                    "endkey": "@high"
+                }
+            },
+            "N1QL": {
+                "Statement": "SELECT name.f.f.f AS _name FROM {{.bucket}} WHERE coins.f > {{json .low}} AND coins.f < {{json .high}} LIMIT {{.limit}}",
+                "Index": {
+                    "Name": "by_coins",
+                    "Keys": "coins.f"
+                }
+            },
+            "Mongo": {
+                "Find": {
+                    "coins.f": {
+                        "$gt": "@low",
+                        "$lt": "@high"
+                    }
+                },
+                "Select": {
+                    "name.f.f.f": 1
+                },
+                "Index": [
+                    "coins.f"
+                ]
+            },
+            "CQL": {
+                "Statement": "SELECT name FROM {{.table}} WHERE coins > ? AND coins < ? LIMIT {{.limit}} ALLOW FILTERING",
+                "Args": [
+                    "@low",
+                    "@high"
+                ],
+                "Index": []
+            },
+            "SQL": {
+                "Statement": "SELECT doc->'name'->'f'->'f'->>'f' AS _name FROM {{.table}} WHERE (doc->'coins'->>'f')::float8 > $1 AND (doc->'coins'->>'f')::float8 < $2 LIMIT {{.limit}}",
+                "Args": [
+                    "@low",
+                    "@high"
+                ],
+                "Index": [
+                    "(doc->'coins'->>'f')::float8"
+                ]
+            },
+            "Expect": {
+                "Kind": "rows",
+                "Filter": [
+                    {
+                        "Field": "coins",
+                        "Op": ">",
+                        "Param": "low"
+                    },
+                    {
+                        "Field": "coins",
+                        "Op": "<",
+                        "Param": "high"
+                    }
+                ]
+            }
+        },
+        {
+            "Name": "email_by_achievement_and_category",
+            "Params": [
+                {
+                    "Name": "achievement",
+                    "Generator": "achievement"
+                },
+                {
+                    "Name": "category",
+                    "Generator": "category"
+                }
+            ],
+            "View": {
+                "Map": "function (doc, meta) { if (doc.achievements && doc.achievements[0] > 0) { emit([doc.achievements[0], doc.category], doc.email.f.f); } }",
+                "Params": {
+                    "startkey": [
+                        0,
+                        "@category"
+                    ],
+                    "endkey": [
+                        "@achievement",
+                        "@category"
+                    ]
+                }
+            },
+            "N1QL": {
+                "Statement": "SELECT email.f.f AS _email FROM {{.bucket}} WHERE category = {{json .category}} AND achievements[0] > 0 AND achievements[0] < {{json .achievement}} LIMIT {{.limit}}",
+                "Index": {
+                    "Name": "by_category_and_achievement",
+                    "Keys": "category, achievements[0]"
+                }
+            },
+            "Mongo": {
+                "Find": {
+                    "category": "@category",
+                    "achievements.0": {
+                        "$gt": 0,
+                        "$lt": "@achievement"
+                    }
+                },
+                "Select": {
+                    "email.f.f": 1
+                },
+                "Index": [
+                    "category",
+                    "achievements.0"
+                ]
+            },
+            "CQL": {
+                "Statement": "SELECT email FROM {{.table}} WHERE category = ? AND achievement > 0 AND achievement < ? LIMIT {{.limit}} ALLOW FILTERING",
+                "Args": [
+                    "@category",
+                    "@achievement"
+                ],
+                "Index": [
+                    "category"
+                ]
+            },
+            "SQL": {
+                "Statement": "SELECT doc->'email'->'f'->>'f' AS _email FROM {{.table}} WHERE (doc->>'category')::int = $1 AND (doc->'achievements'->>0)::int > 0 AND (doc->'achievements'->>0)::int < $2 LIMIT {{.limit}}",
+                "Args": [
+                    "@category",
+                    "@achievement"
+                ],
+                "Index": [
+                    "(doc->>'category')::int",
+                    "(doc->'achievements'->>0)::int"
+                ]
+            },
+            "Expect": {
+                "Kind": "rows",
+                "Filter": [
+                    {
+                        "Field": "category",
+                        "Op": "=",
+                        "Param": "category"
+                    },
+                    {
+                        "Field": "achievement",
+                        "Op": ">",
+                        "Value": 0
+                    },
+                    {
+                        "Field": "achievement",
+                        "Op": "<",
+                        "Param": "achievement"
+                    }
+                ]
+            }
+        },
+        {
+            "Name": "street_by_year_and_coins",
+            "Params": [
+                {
+                    "Name": "year",
+                    "Generator": "year"
+                },
+                {
+                    "Name": "coins",
+                    "Generator": "coins"
+                }
+            ],
+            "View": {
+                "Map": "function (doc, meta) { if (doc.year && doc.coins) { emit([doc.year, doc.coins.f], doc.street.f.f); } }",
+                "Params": {
+                    "startkey": [
+                        "@year",
+                        "@coins"
+                    ],
+                    "endkey": [
+                        "@year",
+                        655.35
+                    ]
+                }
+            },
+            "N1QL": {
+                "Statement": "SELECT street.f.f AS _street FROM {{.bucket}} WHERE year = {{json .year}} AND coins.f > {{json .coins}} AND coins.f < 655.35 LIMIT {{.limit}}",
+                "Index": {
+                    "Name": "by_year_and_coins",
+                    "Keys": "year, coins.f"
+                }
+            },
+            "Mongo": {
+                "Find": {
+                    "year": "@year",
+                    "coins.f": {
+                        "$gt": "@coins",
+                        "$lt": 655.35
+                    }
+                },
+                "Select": {
+                    "street.f.f": 1
+                },
+                "Index": [
+                    "year",
+                    "coins.f"
+                ]
+            },
+            "CQL": {
+                "Statement": "SELECT street FROM {{.table}} WHERE year = ? AND coins > ? AND coins < 655.35 LIMIT {{.limit}} ALLOW FILTERING",
+                "Args": [
+                    "@year",
+                    "@coins"
+                ],
+                "Index": [
+                    "year"
+                ]
+            },
+            "SQL": {
+                "Statement": "SELECT doc->'street'->'f'->>'f' AS _street FROM {{.table}} WHERE (doc->>'year')::int = $1 AND (doc->'coins'->>'f')::float8 > $2 AND (doc->'coins'->>'f')::float8 < 655.35 LIMIT {{.limit}}",
+                "Args": [
+                    "@year",
+                    "@coins"
+                ],
+                "Index": [
+                    "(doc->>'year')::int",
+                    "(doc->'coins'->>'f')::float8"
+                ]
+            },
+            "Expect": {
+                "Kind": "rows",
+                "Filter": [
+                    {
+                        "Field": "year",
+                        "Op": "=",
+                        "Param": "year"
+                    },
+                    {
+                        "Field": "coins",
+                        "Op": ">",
+                        "Param": "coins"
+                    },
+                    {
+                        "Field": "coins",
+                        "Op": "<",
+                        "Value": 655.35
+                    }
+                ]
+            }
+        },
+        {
+            "Name": "coins_stats_by_state_and_year",
+            "Params": [
+                {
+                    "Name": "state",
+                    "Generator": "state"
+                },
+                {
+                    "Name": "year",
+                    "Generator": "year"
+                }
+            ],
+            "View": {
+                "Map": "function (doc, meta) { if (doc.state && doc.year) { emit([doc.state.f, doc.year], doc.coins.f); } }",
+                "Reduce": "_stats",
+                "Params": {
+                    "key": [
+                        "@state",
+                        "@year"
+                    ],
+                    "group": true
+                }
+            },
+            "N1QL": {
+                "Statement": "SELECT COUNT(coins.f) AS count, SUM(coins.f) AS sum, AVG(coins.f) AS avg, MIN(coins.f) AS min, MAX(coins.f) AS max FROM {{.bucket}} WHERE state.f = {{json .state}} AND year = {{json .year}} GROUP BY state.f, year LIMIT {{.limit}}",
+                "Index": {
+                    "Name": "by_state_and_year",
+                    "Keys": "state.f, year"
+                }
+            },
+            "Mongo": {
+                "Pipeline": [
+                    {
+                        "$match": {
+                            "state.f": "@state",
+                            "year": "@year"
+                        }
+                    },
+                    {
+                        "$group": {
+                            "_id": {
+                                "state": "$state.f",
+                                "year": "$year"
+                            },
+                            "count": {
+                                "$sum": 1
+                            },
+                            "sum": {
+                                "$sum": "$coins.f"
+                            },
+                            "avg": {
+                                "$avg": "$coins.f"
+                            },
+                            "min": {
+                                "$min": "$coins.f"
+                            },
+                            "max": {
+                                "$max": "$coins.f"
+                            }
+                        }
+                    }
+                ],
+                "Index": [
+                    "state.f",
+                    "year"
+                ]
+            },
+            "CQL": {
+                "Statement": "SELECT COUNT(*) AS count, SUM(coins) AS sum, AVG(coins) AS avg, MIN(coins) AS min, MAX(coins) AS max FROM {{.table}} WHERE state = ? AND year = ? LIMIT {{.limit}} ALLOW FILTERING",
+                "Args": [
+                    "@state",
+                    "@year"
+                ],
+                "Index": [
+                    "state",
+                    "year"
+                ]
+            },
+            "SQL": {
+                "Statement": "SELECT COUNT(*) AS count, SUM((doc->'coins'->>'f')::float8) AS sum, AVG((doc->'coins'->>'f')::float8) AS avg, MIN((doc->'coins'->>'f')::float8) AS min, MAX((doc->'coins'->>'f')::float8) AS max FROM {{.table}} WHERE doc->'state'->>'f' = $1 AND (doc->>'year')::int = $2 LIMIT {{.limit}}",
+                "Args": [
+                    "@state",
+                    "@year"
+                ],
+                "Index": [
+                    "doc->'state'->>'f'",
+                    "(doc->>'year')::int"
+                ]
+            },
+            "Expect": {
+                "Kind": "stats",
+                "Field": "coins",
+                "Filter": [
+                    {
+                        "Field": "state",
+                        "Op": "=",
+                        "Param": "state"
+                    },
+                    {
+                        "Field": "year",
+                        "Op": "=",
+                        "Param": "year"
+                    }
+                ]
+            }
+        },
+        {
+            "Name": "coins_stats_by_gmtime_and_year",
+            "Params": [
+                {
+                    "Name": "gmtime",
+                    "Generator": "gmtime"
+                },
+                {
+                    "Name": "year",
+                    "Generator": "year"
+                }
+            ],
+            "View": {
+                "Map": "function (doc, meta) { if (doc.gmtime && doc.year) { emit([doc.gmtime, doc.year], doc.coins.f); } }",
+                "Reduce": "_stats",
+                "Params": {
+                    "key": [
+                        "@gmtime",
+                        "@year"
+                    ],
+                    "group_level": 2
+                }
+            },
+            "N1QL": {
+                "Statement": "SELECT COUNT(coins.f) AS count, SUM(coins.f) AS sum, AVG(coins.f) AS avg, MIN(coins.f) AS min, MAX(coins.f) AS max FROM {{.bucket}} WHERE gmtime = {{json .gmtime}} AND year = {{json .year}} GROUP BY gmtime, year LIMIT {{.limit}}",
+                "Index": {
+                    "Name": "by_gmtime_and_year",
+                    "Keys": "gmtime, year"
+                }
+            },
+            "Mongo": {
+                "Pipeline": [
+                    {
+                        "$match": {
+                            "gmtime": "@gmtime",
+                            "year": "@year"
+                        }
+                    },
+                    {
+                        "$group": {
+                            "_id": {
+                                "gmtime": "$gmtime",
+                                "year": "$year"
+                            },
+                            "count": {
+                                "$sum": 1
+                            },
+                            "sum": {
+                                "$sum": "$coins.f"
+                            },
+                            "avg": {
+                                "$avg": "$coins.f"
+                            },
+                            "min": {
+                                "$min": "$coins.f"
+                            },
+                            "max": {
+                                "$max": "$coins.f"
+                            }
+                        }
+                    }
+                ],
+                "Index": [
+                    "gmtime",
+                    "year"
+                ]
+            },
+            "CQL": {
+                "Statement": "SELECT COUNT(*) AS count, SUM(coins) AS sum, AVG(coins) AS avg, MIN(coins) AS min, MAX(coins) AS max FROM {{.table}} WHERE gmtime = ? AND year = ? LIMIT {{.limit}} ALLOW FILTERING",
+                "Args": [
+                    "@gmtime",
+                    "@year"
+                ],
+                "Index": [
+                    "gmtime",
+                    "year"
+                ]
+            },
+            "SQL": {
+                "Statement": "SELECT COUNT(*) AS count, SUM((doc->'coins'->>'f')::float8) AS sum, AVG((doc->'coins'->>'f')::float8) AS avg, MIN((doc->'coins'->>'f')::float8) AS min, MAX((doc->'coins'->>'f')::float8) AS max FROM {{.table}} WHERE doc->'gmtime' = $1::jsonb AND (doc->>'year')::int = $2 LIMIT {{.limit}}",
+                "Args": [
+                    "@gmtime",
+                    "@year"
+                ],
+                "Index": [
+                    "doc->'gmtime'",
+                    "(doc->>'year')::int"
+                ]
+            },
+            "Expect": {
+                "Kind": "stats",
+                "Field": "coins",
+                "Filter": [
+                    {
+                        "Field": "gmtime",
+                        "Op": "=",
+                        "Param": "gmtime"
+                    },
+                    {
+                        "Field": "year",
+                        "Op": "=",
+                        "Param": "year"
+                    }
+                ]
+            }
+        },
+        {
+            "Name": "coins_stats_by_full_state_and_year",
+            "Params": [
+                {
+                    "Name": "full_state",
+                    "Generator": "full_state"
+                },
+                {
+                    "Name": "year",
+                    "Generator": "year"
+                }
+            ],
+            "View": {
+                "Map": "function (doc, meta) { if (doc.full_state && doc.year) { emit([doc.full_state.f, doc.year], doc.coins.f); } }",
+                "Reduce": "_stats",
+                "Params": {
+                    "key": [
+                        "@full_state",
+                        "@year"
+                    ],
+                    "group": true
+                }
+            },
+            "N1QL": {
+                "Statement": "SELECT COUNT(coins.f) AS count, SUM(coins.f) AS sum, AVG(coins.f) AS avg, MIN(coins.f) AS min, MAX(coins.f) AS max FROM {{.bucket}} WHERE full_state.f = {{json .full_state}} AND year = {{json .year}} GROUP BY full_state.f, year LIMIT {{.limit}}",
+                "Index": {
+                    "Name": "by_full_state_and_year",
+                    "Keys": "full_state.f, year"
+                }
+            },
+            "Mongo": {
+                "Pipeline": [
+                    {
+                        "$match": {
+                            "full_state.f": "@full_state",
+                            "year": "@year"
+                        }
+                    },
+                    {
+                        "$group": {
+                            "_id": {
+                                "full_state": "$full_state.f",
+                                "year": "$year"
+                            },
+                            "count": {
+                                "$sum": 1
+                            },
+                            "sum": {
+                                "$sum": "$coins.f"
+                            },
+                            "avg": {
+                                "$avg": "$coins.f"
+                            },
+                            "min": {
+                                "$min": "$coins.f"
+                            },
+                            "max": {
+                                "$max": "$coins.f"
+                            }
+                        }
+                    }
+                ],
+                "Index": [
+                    "full_state.f",
+                    "year"
+                ]
+            },
+            "CQL": {
+                "Statement": "SELECT COUNT(*) AS count, SUM(coins) AS sum, AVG(coins) AS avg, MIN(coins) AS min, MAX(coins) AS max FROM {{.table}} WHERE full_state = ? AND year = ? LIMIT {{.limit}} ALLOW FILTERING",
+                "Args": [
+                    "@full_state",
+                    "@year"
+                ],
+                "Index": [
+                    "full_state",
+                    "year"
+                ]
+            },
+            "SQL": {
+                "Statement": "SELECT COUNT(*) AS count, SUM((doc->'coins'->>'f')::float8) AS sum, AVG((doc->'coins'->>'f')::float8) AS avg, MIN((doc->'coins'->>'f')::float8) AS min, MAX((doc->'coins'->>'f')::float8) AS max FROM {{.table}} WHERE doc->'full_state'->>'f' = $1 AND (doc->>'year')::int = $2 LIMIT {{.limit}}",
+                "Args": [
+                    "@full_state",
+                    "@year"
+                ],
+                "Index": [
+                    "doc->'full_state'->>'f'",
+                    "(doc->>'year')::int"
+                ]
+            },
+            "Expect": {
+                "Kind": "stats",
+                "Field": "coins",
+                "Filter": [
+                    {
+                        "Field": "full_state",
+                        "Op": "=",
+                        "Param": "full_state"
+                    },
+                    {
+                        "Field": "year",
+                        "Op": "=",
+                        "Param": "year"
+                    }
+                ]
+            }
+        },
+        {
+            "Name": "name_and_email_and_street_and_achievements_and_coins_by_city",
+            "Params": [
+                {
+                    "Name": "city",
+                    "Generator": "city"
+                }
+            ],
+            "View": {
+                "Map": "function (doc, meta) { if (doc.city && doc.city.f) { emit(doc.city.f.f, [doc.name.f.f.f, doc.email.f.f, doc.street.f.f, doc.achievements, doc.coins.f]); } }",
+                "Params": {
+                    "key": "@city"
+                }
+            },
+            "N1QL": {
+                "Statement": "SELECT name.f.f.f AS _name, email.f.f AS _email, street.f.f AS _street, achievements, coins.f AS _coins FROM {{.bucket}} WHERE city.f.f = {{json .city}} LIMIT {{.limit}}",
+                "Index": {
+                    "Name": "by_city",
+                    "Keys": "city.f.f"
+                }
+            },
+            "Mongo": {
+                "Find": {
+                    "city.f.f": "@city"
+                },
+                "Select": {
+                    "name.f.f.f": 1,
+                    "email.f.f": 1,
+                    "street.f.f": 1,
+                    "achievements": 1,
+                    "coins.f": 1
+                },
+                "Index": [
+                    "city.f.f"
+                ]
+            },
+            "CQL": {
+                "Statement": "SELECT name, email, street, achievements, coins FROM {{.table}} WHERE city = ? LIMIT {{.limit}}",
+                "Args": [
+                    "@city"
+                ],
+                "Index": [
+                    "city"
+                ]
+            },
+            "SQL": {
+                "Statement": "SELECT doc->'name'->'f'->'f'->>'f' AS _name, doc->'email'->'f'->>'f' AS _email, doc->'street'->'f'->>'f' AS _street, doc->'achievements' AS achievements, (doc->'coins'->>'f')::float8 AS _coins FROM {{.table}} WHERE doc->'city'->'f'->>'f' = $1 LIMIT {{.limit}}",
+                "Args": [
+                    "@city"
+                ],
+                "Index": [
+                    "doc->'city'->'f'->>'f'"
+                ]
+            },
+            "Expect": {
+                "Kind": "rows",
+                "Filter": [
+                    {
+                        "Field": "city",
+                        "Op": "=",
+                        "Param": "city"
+                    }
+                ]
+            }
+        },
+        {
+            "Name": "street_and_name_and_email_and_achievement_and_coins_by_county",
+            "Params": [
+                {
+                    "Name": "county",
+                    "Generator": "county"
+                }
+            ],
+            "View": {
+                "Map": "function (doc, meta) { if (doc.county && doc.county.f) { emit(doc.county.f.f, [doc.street.f.f, doc.name.f.f.f, doc.email.f.f, doc.achievements[0], 2 * doc.coins.f]); } }",
+                "Params": {
+                    "key": "@county"
+                }
+            },
+            "N1QL": {
+                "Statement": "SELECT street.f.f AS _street, name.f.f.f AS _name, email.f.f AS _email, achievements[0] AS achievement, 2*coins.f AS _coins FROM {{.bucket}} WHERE county.f.f = {{json .county}} LIMIT {{.limit}}",
+                "Index": {
+                    "Name": "by_county",
+                    "Keys": "county.f.f"
+                }
+            },
+            "Mongo": {
+                "Find": {
+                    "county.f.f": "@county"
+                },
+                "Select": {
+                    "street.f.f": 1,
+                    "name.f.f.f": 1,
+                    "email.f.f": 1,
+                    "achievements": {
+                        "$slice": 1
+                    },
+                    "coins.f": 1
+                },
+                "Index": [
+                    "county.f.f"
+                ]
+            },
+            "CQL": {
+                "Statement": "SELECT street, name, email, achievement, coins FROM {{.table}} WHERE county = ? LIMIT {{.limit}}",
+                "Args": [
+                    "@county"
+                ],
+                "Index": [
+                    "county"
+                ]
+            },
+            "SQL": {
+                "Statement": "SELECT doc->'street'->'f'->>'f' AS _street, doc->'name'->'f'->'f'->>'f' AS _name, doc->'email'->'f'->>'f' AS _email, (doc->'achievements'->>0)::int AS achievement, 2 * (doc->'coins'->>'f')::float8 AS _coins FROM {{.table}} WHERE doc->'county'->'f'->>'f' = $1 LIMIT {{.limit}}",
+                "Args": [
+                    "@county"
+                ],
+                "Index": [
+                    "doc->'county'->'f'->>'f'"
+                ]
+            },
+            "Expect": {
+                "Kind": "rows",
+                "Filter": [
+                    {
+                        "Field": "county",
+                        "Op": "=",
+                        "Param": "county"
+                    }
+                ]
+            }
+        },
+        {
+            "Name": "category_name_and_email_and_street_and_gmtime_and_year_by_country",
+            "Params": [
+                {
+                    "Name": "country",
+                    "Generator": "country"
+                }
+            ],
+            "View": {
+                "Map": "function (doc, meta) { if (doc.country) { emit(doc.country.f, [doc.category, doc.name.f.f.f, doc.email.f.f, doc.street.f.f, doc.gmtime, doc.year]); } }",
+                "Params": {
+                    "key": "@country"
+                }
+            },
+            "N1QL": {
+                "Statement": "SELECT category, name.f.f.f AS _name, email.f.f AS _email, street.f.f AS _street, gmtime, year FROM {{.bucket}} WHERE country.f = {{json .country}} LIMIT {{.limit}}",
+                "Index": {
+                    "Name": "by_country",
+                    "Keys": "country.f"
+                }
+            },
+            "Mongo": {
+                "Find": {
+                    "country.f": "@country"
+                },
+                "Select": {
+                    "category": 1,
+                    "name.f.f.f": 1,
+                    "email.f.f": 1,
+                    "street.f.f": 1,
+                    "gmtime": 1,
+                    "year": 1
+                },
+                "Index": [
+                    "country.f"
+                ]
+            },
+            "CQL": {
+                "Statement": "SELECT category, name, email, street, gmtime, year FROM {{.table}} WHERE country = ? LIMIT {{.limit}}",
+                "Args": [
+                    "@country"
+                ],
+                "Index": [
+                    "country"
+                ]
+            },
+            "SQL": {
+                "Statement": "SELECT (doc->>'category')::int AS category, doc->'name'->'f'->'f'->>'f' AS _name, doc->'email'->'f'->>'f' AS _email, doc->'street'->'f'->>'f' AS _street, doc->'gmtime' AS gmtime, (doc->>'year')::int AS year FROM {{.table}} WHERE doc->'country'->>'f' = $1 LIMIT {{.limit}}",
+                "Args": [
+                    "@country"
+                ],
+                "Index": [
+                    "doc->'country'->>'f'"
+                ]
+            },
+            "Expect": {
+                "Kind": "rows",
+                "Filter": [
+                    {
+                        "Field": "country",
+                        "Op": "=",
+                        "Param": "country"
+                    }
+                ]
+            }
+        },
+        {
+            "Name": "calc_by_city",
+            "Params": [
+                {
+                    "Name": "city",
+                    "Generator": "city"
+                }
+            ],
+            "View": {
+                "Map": "function (doc, meta) { if (doc.city && doc.city.f) { emit(doc.city.f.f, doc.coins.f * doc.category + doc.year); } }",
+                "Params": {
+                    "key": "@city"
+                }
+            }
+        },
+        {
+            "Name": "calc_by_county",
+            "Params": [
+                {
+                    "Name": "county",
+                    "Generator": "county"
+                }
+            ],
+            "View": {
+                "Map": "function (doc, meta) { if (doc.county && doc.county.f) { emit(doc.county.f.f, doc.coins.f * doc.category + doc.year); } }",
+                "Params": {
+                    "key": "@county"
+                }
+            }
+        },
+        {
+            "Name": "calc_by_realm",
+            "Params": [
+                {
+                    "Name": "realm",
+                    "Generator": "realm"
+                }
+            ],
+            "View": {
+                "Map": "function (doc, meta) { if (doc.realm) { emit(doc.realm.f, doc.coins.f * doc.category + doc.year); } }",
+                "Params": {
+                    "key": "@realm"
+                }
+            }
+        },
+        {
+            "Name": "body_by_city",
+            "Params": [
+                {
+                    "Name": "city",
+                    "Generator": "city"
+                }
+            ],
+            "View": {
+                "Map": "function (doc, meta) { if (doc.city && doc.city.f) { emit(doc.city.f.f, doc.body); } }",
+                "Params": {
+                    "key": "@city"
+                }
+            },
+            "N1QL": {
+                "Statement": "SELECT body FROM {{.bucket}} WHERE city.f.f = {{json .city}} LIMIT {{.limit}}",
+                "Index": {
+                    "Name": "by_city",
+                    "Keys": "city.f.f"
+                }
+            },
+            "Mongo": {
+                "Find": {
+                    "city.f.f": "@city"
+                },
+                "Select": {
+                    "body": 1
+                },
+                "Index": [
+                    "city.f.f"
+                ]
+            },
+            "CQL": {
+                "Statement": "SELECT value FROM {{.table}} WHERE city = ? LIMIT {{.limit}}",
+                "Args": [
+                    "@city"
+                ],
+                "Index": [
+                    "city"
+                ]
+            },
+            "SQL": {
+                "Statement": "SELECT doc->>'body' AS body FROM {{.table}} WHERE doc->'city'->'f'->>'f' = $1 LIMIT {{.limit}}",
+                "Args": [
+                    "@city"
+                ],
+                "Index": [
+                    "doc->'city'->'f'->>'f'"
+                ]
+            },
+            "Expect": {
+                "Kind": "rows",
+                "Filter": [
+                    {
+                        "Field": "city",
+                        "Op": "=",
+                        "Param": "city"
+                    }
+                ]
+            }
+        },
+        {
+            "Name": "body_by_realm",
+            "Params": [
+                {
+                    "Name": "realm",
+                    "Generator": "realm"
+                }
+            ],
+            "View": {
+                "Map": "function (doc, meta) { if (doc.realm) { emit(doc.realm.f, doc.body); } }",
+                "Params": {
+                    "key": "@realm"
+                }
+            },
+            "N1QL": {
+                "Statement": "SELECT body FROM {{.bucket}} WHERE realm.f = {{json .realm}} LIMIT {{.limit}}",
+                "Index": {
+                    "Name": "by_realm",
+                    "Keys": "realm.f"
+                }
+            },
+            "Mongo": {
+                "Find": {
+                    "realm.f": "@realm"
+                },
+                "Select": {
+                    "body": 1
+                },
+                "Index": [
+                    "realm.f"
+                ]
+            },
+            "CQL": {
+                "Statement": "SELECT value FROM {{.table}} WHERE realm = ? LIMIT {{.limit}}",
+                "Args": [
+                    "@realm"
+                ],
+                "Index": [
+                    "realm"
+                ]
+            },
+            "SQL": {
+                "Statement": "SELECT doc->>'body' AS body FROM {{.table}} WHERE doc->'realm'->>'f' = $1 LIMIT {{.limit}}",
+                "Args": [
+                    "@realm"
+                ],
+                "Index": [
+                    "doc->'realm'->>'f'"
+                ]
+            },
+            "Expect": {
+                "Kind": "rows",
+                "Filter": [
+                    {
+                        "Field": "realm",
+                        "Op": "=",
+                        "Param": "realm"
+                    }
+                ]
+            }
+        },
+        {
+            "Name": "body_by_country",
+            "Params": [
+                {
+                    "Name": "country",
+                    "Generator": "country"
+                }
+            ],
+            "View": {
+                "Map": "function (doc, meta) { if (doc.country) { emit(doc.country.f, doc.body); } }",
+                "Params": {
+                    "key": "@country"
+                }
+            },
+            "N1QL": {
+                "Statement": "SELECT body FROM {{.bucket}} WHERE country.f = {{json .country}} LIMIT {{.limit}}",
+                "Index": {
+                    "Name": "by_country",
+                    "Keys": "country.f"
+                }
+            },
+            "Mongo": {
+                "Find": {
+                    "country.f": "@country"
+                },
+                "Select": {
+                    "body": 1
+                },
+                "Index": [
+                    "country.f"
+                ]
+            },
+            "CQL": {
+                "Statement": "SELECT value FROM {{.table}} WHERE country = ? LIMIT {{.limit}}",
+                "Args": [
+                    "@country"
+                ],
+                "Index": [
+                    "country"
+                ]
+            },
+            "SQL": {
+                "Statement": "SELECT doc->>'body' AS body FROM {{.table}} WHERE doc->'country'->>'f' = $1 LIMIT {{.limit}}",
+                "Args": [
+                    "@country"
+                ],
+                "Index": [
+                    "doc->'country'->>'f'"
+                ]
+            },
+            "Expect": {
+                "Kind": "rows",
+                "Filter": [
+                    {
+                        "Field": "country",
+                        "Op": "=",
+                        "Param": "country"
+                    }
+                ]
+            }
+        },
+        {
+            "Name": "distinct_states",
+            "View": {
+                "Map": "function (doc, meta) { if (doc.state) { emit(doc.state.f, null); } }",
+                "Reduce": "_count",
+                "Params": {
+                    "group": true
+                }
+            },
+            "N1QL": {
+                "Statement": "SELECT DISTINCT state.f AS state FROM {{.bucket}} LIMIT {{.limit}}",
+                "Index": {
+                    "Name": "#primary",
+                    "Keys": ""
+                }
+            },
+            "Mongo": {
+                "Distinct": "state.f",
+                "Index": [
+                    "state.f"
+                ]
+            },
+            "SQL": {
+                "Statement": "SELECT DISTINCT doc->'state'->>'f' AS state FROM {{.table}} LIMIT {{.limit}}",
+                "Index": [
+                    "doc->'state'->>'f'"
+                ]
+            },
+            "Expect": {
+                "Kind": "distinct",
+                "Field": "state"
+            }
+        },
+        {
+            "Name": "distinct_full_states",
+            "View": {
+                "Map": "function (doc, meta) { if (doc.full_state) { emit(doc.full_state.f, null); } }",
+                "Reduce": "_count",
+                "Params": {
+                    "group": true
+                }
+            },
+            "N1QL": {
+                "Statement": "SELECT DISTINCT full_state.f AS full_state FROM {{.bucket}} LIMIT {{.limit}}",
+                "Index": {
+                    "Name": "#primary",
+                    "Keys": ""
+                }
+            },
+            "Mongo": {
+                "Distinct": "full_state.f",
+                "Index": [
+                    "full_state.f"
+                ]
+            },
+            "SQL": {
+                "Statement": "SELECT DISTINCT doc->'full_state'->>'f' AS full_state FROM {{.table}} LIMIT {{.limit}}",
+                "Index": [
+                    "doc->'full_state'->>'f'"
+                ]
+            },
+            "Expect": {
+                "Kind": "distinct",
+                "Field": "full_state"
+            }
+        },
+        {
+            "Name": "distinct_years",
+            "View": {
+                "Map": "function (doc, meta) { if (doc.year) { emit(doc.year, null); } }",
+                "Reduce": "_count",
+                "Params": {
+                    "group": true
+                }
+            },
+            "N1QL": {
+                "Statement": "SELECT DISTINCT year FROM {{.bucket}} LIMIT {{.limit}}",
+                "Index": {
+                    "Name": "#primary",
+                    "Keys": ""
+                }
+            },
+            "Mongo": {
+                "Distinct": "year",
+                "Index": [
+                    "year"
+                ]
+            },
+            "SQL": {
+                "Statement": "SELECT DISTINCT (doc->>'year')::int AS year FROM {{.table}} LIMIT {{.limit}}",
+                "Index": [
+                    "(doc->>'year')::int"
+                ]
+            },
+            "Expect": {
+                "Kind": "distinct",
+                "Field": "year"
+            }
+        }
+    ]
+}
+`
@@ -0,0 +1,95 @@
+package databases
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+)
+
+type ErrorClass string
+
+const (
+	NotFound      ErrorClass = "NotFound"
+	AlreadyExists ErrorClass = "AlreadyExists"
+	CASConflict   ErrorClass = "CASConflict"
+	Timeout       ErrorClass = "Timeout"
+	Temporary     ErrorClass = "Temporary"
+	Connection    ErrorClass = "Connection"
+	ServerError   ErrorClass = "ServerError"
+)
+
+var ErrorClasses = []ErrorClass{
+	NotFound, AlreadyExists, CASConflict, Timeout, Temporary, Connection, ServerError,
+}
+
+// Error is a driver error classified into the shared taxonomy.
+type Error struct {
+	Class ErrorClass
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return string(e.Class) + ": " + e.Err.Error()
+}
+
+// ErrNotFound is returned by Read when the key does not exist.
+var ErrNotFound = &Error{NotFound, errors.New("not found")}
+
+// ClassOf returns the class of an error returned by a driver. Errors that
+// drivers did not classify are treated as server errors.
+func ClassOf(err error) ErrorClass {
+	if e, ok := err.(*Error); ok {
+		return e.Class
+	}
+	if class, ok := networkErrorClass(err); ok {
+		return class
+	}
+	return ServerError
+}
+
+func networkErrorClass(err error) (ErrorClass, bool) {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return Connection, true
+	}
+	if netErr, ok := err.(net.Error); ok {
+		if netErr.Timeout() {
+			return Timeout, true
+		}
+		return Connection, true
+	}
+	return "", false
+}
+
+// ignoreSchemaError drops errors of setup and teardown statements reporting
+// that the object to create already exists or the one to drop is missing, so
+// that provisioning can be repeated.
+func ignoreSchemaError(err error, messages ...string) error {
+	if err == nil {
+		return nil
+	}
+	text := strings.ToLower(err.Error())
+	for _, message := range messages {
+		if strings.Contains(text, strings.ToLower(message)) {
+			return nil
+		}
+	}
+	return err
+}
+
+func classify(class ErrorClass, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	if netClass, ok := networkErrorClass(err); ok {
+		class = netClass
+	}
+	return &Error{class, err}
+}
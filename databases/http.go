@@ -0,0 +1,251 @@
+package databases
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// HTTPRequest is the template of the request of an operation. URL, Body and
+// header values are Go templates, see httpData.
+type HTTPRequest struct {
+	Method  string
+	URL     string
+	Headers map[string]string
+	Body    string
+	Success []int
+	Rows    string
+}
+
+type HTTPConfig struct {
+	Create    HTTPRequest
+	Read      HTTPRequest
+	Update    HTTPRequest
+	Delete    HTTPRequest
+	Query     HTTPRequest
+	Balancing string
+}
+
+// httpData is passed to request templates. Address is the address chosen by
+// the balancing policy, Query and Params are only set for queries.
+type httpData struct {
+	Address string
+	Key     string
+	Value   interface{}
+	Expiry  int
+	Query   string
+	Params  map[string]interface{}
+}
+
+var httpFuncs = template.FuncMap{
+	"json": func(value interface{}) (string, error) {
+		encoded, err := json.Marshal(value)
+		return string(encoded), err
+	},
+	"base64": func(value []byte) string {
+		return base64.StdEncoding.EncodeToString(value)
+	},
+}
+
+type httpOperation struct {
+	method  string
+	url     *template.Template
+	body    *template.Template
+	headers map[string]*template.Template
+	success []int
+	rows    string
+}
+
+func newHTTPOperation(name, method string, request HTTPRequest) *httpOperation {
+	if request.URL == "" {
+		return nil
+	}
+	parse := func(text string) *template.Template {
+		t, err := template.New(name).Funcs(httpFuncs).Parse(text)
+		if err != nil {
+			log.Fatalf("HTTP %s template: %v", name, err)
+		}
+		return t
+	}
+	op := &httpOperation{
+		method:  method,
+		url:     parse(request.URL),
+		body:    parse(request.Body),
+		headers: map[string]*template.Template{},
+		success: request.Success,
+		rows:    request.Rows,
+	}
+	if request.Method != "" {
+		op.method = strings.ToUpper(request.Method)
+	}
+	for name, value := range request.Headers {
+		op.headers[name] = parse(value)
+	}
+	return op
+}
+
+func (op *httpOperation) request(data httpData) (*http.Request, error) {
+	uri, err := execute(op.url, data)
+	if err != nil {
+		return nil, err
+	}
+	body, err := execute(op.body, data)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(op.method, uri, strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, t := range op.headers {
+		value, err := execute(t, data)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set(name, value)
+	}
+	return req, nil
+}
+
+// succeeded checks the status code against the configured codes, any 2xx
+// code by default.
+func (op *httpOperation) succeeded(code int) bool {
+	if len(op.success) == 0 {
+		return code >= 200 && code < 300
+	}
+	for _, success := range op.success {
+		if code == success {
+			return true
+		}
+	}
+	return false
+}
+
+// HTTP is a driver for services exposing documents over an HTTP API. Every
+// operation sends the request rendered from its template to one of
+// Database.Addresses, see README.
+type HTTP struct {
+	client     *RestClient
+	Catalog    *Catalog
+	operations map[string]*httpOperation
+}
+
+func (h *HTTP) Init(config Config) {
+	config.Client.sessionReuse("HTTP", PooledSessions, SharedSessions, NoSessionReuse)
+	tlsConfig, err := config.TLS.config()
+	if err != nil {
+		log.Fatal(err)
+	}
+	conns := newConnStats(config.Client)
+	client := &http.Client{
+		Transport: conns.transport(config.Client, tlsConfig, MaxIdleConnsPerHost),
+		Timeout:   config.Client.opTimeout(0),
+	}
+	h.client = newRestClient(client, config.Addresses, config.HTTP.Balancing)
+	h.client.conns = conns
+	h.client.username, h.client.password = config.Username, config.Password
+
+	h.operations = map[string]*httpOperation{
+		"create": newHTTPOperation("create", "PUT", config.HTTP.Create),
+		"read":   newHTTPOperation("read", "GET", config.HTTP.Read),
+		"update": newHTTPOperation("update", "PUT", config.HTTP.Update),
+		"delete": newHTTPOperation("delete", "DELETE", config.HTTP.Delete),
+		"query":  newHTTPOperation("query", "GET", config.HTTP.Query),
+	}
+	h.Catalog = config.queryCatalog()
+}
+
+func (h *HTTP) Shutdown() {}
+
+func (h *HTTP) ReportSummary() {
+	h.client.conns.ReportSummary()
+	h.client.reportEndpoints("Endpoints")
+}
+
+// do sends the request of the operation and returns the response body.
+func (h *HTTP) do(name string, data httpData) ([]byte, error) {
+	op := h.operations[name]
+	if op == nil {
+		return nil, &Error{ServerError, fmt.Errorf("no HTTP request for %s", name)}
+	}
+	return h.client.send(func(uri string) ([]byte, int, error) {
+		data.Address = uri
+		req, err := op.request(data)
+		if err != nil {
+			return nil, 0, classify(ServerError, err)
+		}
+		if h.client.username != "" {
+			req.SetBasicAuth(h.client.username, h.client.password)
+		}
+
+		resp, err := h.client.client.Do(req)
+		if err != nil {
+			return nil, 0, classify(Connection, err)
+		}
+		defer resp.Body.Close()
+
+		if !op.succeeded(resp.StatusCode) {
+			return nil, resp.StatusCode, statusError(resp.StatusCode)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		return body, resp.StatusCode, classify(Connection, err)
+	})
+}
+
+func (h *HTTP) Create(key string, value interface{}, expiry int) error {
+	_, err := h.do("create", httpData{Key: key, Value: value, Expiry: expiry})
+	return err
+}
+
+func (h *HTTP) Read(key string) (interface{}, error) {
+	body, err := h.do("read", httpData{Key: key})
+	if err != nil {
+		return nil, err
+	}
+	return decodeRaw(body)
+}
+
+func (h *HTTP) Update(key string, value interface{}, expiry int) error {
+	_, err := h.do("update", httpData{Key: key, Value: value, Expiry: expiry})
+	return err
+}
+
+func (h *HTTP) Delete(key string) error {
+	_, err := h.do("delete", httpData{Key: key})
+	return err
+}
+
+// Query sends the query request with the name and parameters of the catalog
+// query. The response is a JSON array of rows, or an object with the rows in
+// the field named by Rows.
+func (h *HTTP) Query(key string, args []interface{}) ([]map[string]interface{}, error) {
+	q, values, err := h.Catalog.Prepare(args)
+	if err != nil {
+		return nil, err
+	}
+	body, err := h.do("query", httpData{Key: key, Query: q.Name, Params: values})
+	if err != nil {
+		return nil, err
+	}
+	return decodeHTTPRows(body, h.operations["query"].rows)
+}
+
+func decodeHTTPRows(body []byte, field string) ([]map[string]interface{}, error) {
+	rows := []map[string]interface{}{}
+	if field == "" {
+		return rows, classify(ServerError, json.Unmarshal(body, &rows))
+	}
+	response := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, &Error{ServerError, err}
+	}
+	if raw, ok := response[field]; ok {
+		return rows, classify(ServerError, json.Unmarshal(raw, &rows))
+	}
+	return nil, &Error{ServerError, fmt.Errorf("no %s field in query response", field)}
+}
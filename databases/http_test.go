@@ -0,0 +1,74 @@
+package databases
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTP(t *testing.T) {
+	documents := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Path[len("/docs/"):]
+		switch r.Method {
+		case "PUT":
+			if r.Header.Get("X-Expiry") != "60" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body, _ := ioutil.ReadAll(r.Body)
+			documents[key] = string(body)
+			w.WriteHeader(http.StatusCreated)
+		case "GET":
+			document, ok := documents[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write([]byte(document))
+		}
+	}))
+	defer server.Close()
+
+	h := &HTTP{}
+	h.Init(Config{
+		Addresses: []string{server.URL},
+		HTTP: HTTPConfig{
+			Create: HTTPRequest{
+				URL:     "{{.Address}}/docs/{{.Key}}",
+				Headers: map[string]string{"X-Expiry": "{{.Expiry}}"},
+				Body:    "{{json .Value}}",
+				Success: []int{201},
+			},
+			Read: HTTPRequest{URL: "{{.Address}}/docs/{{.Key}}"},
+		},
+	})
+
+	if err := h.Create("key1", map[string]interface{}{"city": "Paris"}, 60); err != nil {
+		t.Fatal(err)
+	}
+	value, err := h.Read("key1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc, ok := value.(map[string]interface{}); !ok || doc["city"] != "Paris" {
+		t.Errorf("unexpected document: %v", value)
+	}
+	if _, err := h.Read("key2"); ClassOf(err) != NotFound {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := h.Delete("key1"); err == nil {
+		t.Error("delete is not configured")
+	}
+}
+
+func TestDecodeHTTPRows(t *testing.T) {
+	rows, err := decodeHTTPRows([]byte(`{"hits": [{"year": 1990}]}`), "hits")
+	if err != nil || len(rows) != 1 || rows[0]["year"] != 1990.0 {
+		t.Errorf("unexpected rows: %v (%v)", rows, err)
+	}
+	if _, err := decodeHTTPRows([]byte(`{"rows": []}`), "hits"); err == nil {
+		t.Error("missing field was accepted")
+	}
+}
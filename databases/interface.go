@@ -1,26 +1,122 @@
 package databases
 
+import (
+	"encoding/json"
+)
+
 type Config struct {
-	Driver    string
-	Name      string
-	Table     string
-	Addresses []string
-	Username  string
-	Password  string
+	Driver      string
+	Name        string
+	Table       string
+	Addresses   []string
+	Client      ClientConfig
+	Username    string
+	Password    string
+	Credentials string
+	TLS         TLSConfig
+	Catalog     string
+	Retry       RetryConfig
+	CQL         CQLConfig
+	Tuq         TuqConfig
+	Redis       RedisConfig
+	Bolt        BoltConfig
+	HTTP        HTTPConfig
+	Remote      RemoteConfig
+	Durability  DurabilityConfig
+
+	// QueryCatalog is the catalog loaded from Catalog by the caller, drivers
+	// load it themselves when it is not set.
+	QueryCatalog *Catalog `json:"-"`
 }
 
+// DurabilityConfig holds write durability and read consistency levels. Their
+// meaning depends on the driver, see README.
+type DurabilityConfig struct {
+	Write       string
+	Journal     bool
+	PersistTo   int
+	ReplicateTo int
+	Read        string
+}
+
+// Values passed to Create and Update are either documents
+// (map[string]interface{}) or raw binary payloads ([]byte). Expiry is a TTL in
+// seconds, zero means that the value never expires. Read returns the stored
+// value in the same form, Query returns result rows. Errors are classified as
+// *Error values, see ClassOf.
 type Database interface {
 	Init(config Config)
 
 	Shutdown()
 
-	Create(key string, value map[string]interface{}) error
+	Create(key string, value interface{}, expiry int) error
 
-	Read(key string) error
+	Read(key string) (interface{}, error)
 
-	Update(key string, value map[string]interface{}) error
+	Update(key string, value interface{}, expiry int) error
 
 	Delete(key string) error
 
-	Query(key string, value []interface{}) error
+	Query(key string, value []interface{}) ([]map[string]interface{}, error)
+}
+
+// Reporter is implemented by databases that collect their own statistics.
+type Reporter interface {
+	ReportSummary()
+}
+
+// Durable is implemented by databases with tunable durability. Durability
+// describes the active write durability and read consistency levels.
+type Durable interface {
+	Durability() string
+}
+
+// Provisioner is implemented by databases that can create and drop the
+// artifacts (views, indexes, schemas) required by the given query indexes.
+// Both methods are called on an initialized database.
+type Provisioner interface {
+	Setup(indexes []string) error
+
+	Teardown(indexes []string) error
+}
+
+// Pagination styles of scans: Offset skips the rows returned by previous
+// pages, Keyset continues after the last returned key and Cursor reads all
+// pages through a single server-side cursor.
+const (
+	OffsetPagination = "Offset"
+	KeysetPagination = "Keyset"
+	CursorPagination = "Cursor"
+)
+
+type ScanOptions struct {
+	Length     int
+	PageSize   int
+	Pagination string
+}
+
+// pageLimit returns the number of rows requested by the next page.
+func (options ScanOptions) pageLimit(scanned int) int {
+	limit := options.Length - scanned
+	if options.PageSize > 0 && options.PageSize < limit {
+		limit = options.PageSize
+	}
+	return limit
+}
+
+// Scanner is implemented by databases that support range scans in key order.
+// Scan reads up to options.Length documents starting with startKey and
+// returns the number of documents read.
+type Scanner interface {
+	Scan(startKey string, options ScanOptions) (int, error)
+}
+
+// decodeRaw decodes JSON documents and returns any other value as is.
+func decodeRaw(raw []byte) (interface{}, error) {
+	if len(raw) == 0 || raw[0] != '{' {
+		return raw, nil
+	}
+	result := map[string]interface{}{}
+	err := json.Unmarshal(raw, &result)
+	return result, classify(ServerError, err)
 }
@@ -0,0 +1,19 @@
+package databases
+
+import "testing"
+
+func TestPageLimit(t *testing.T) {
+	options := ScanOptions{Length: 50, PageSize: 20}
+	pages := []int{}
+	for scanned := 0; scanned < options.Length; scanned += options.pageLimit(scanned) {
+		pages = append(pages, options.pageLimit(scanned))
+	}
+	if len(pages) != 3 || pages[0] != 20 || pages[2] != 10 {
+		t.Errorf("unexpected pages: %v", pages)
+	}
+
+	options.PageSize = 0
+	if limit := options.pageLimit(0); limit != 50 {
+		t.Errorf("unexpected limit without paging: %v", limit)
+	}
+}
@@ -1,7 +1,12 @@
 package databases
 
 import (
+	"fmt"
 	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"labix.org/v2/mgo"
@@ -12,281 +17,375 @@ type MongoDB struct {
 	Session        *mgo.Session
 	DBName         string
 	CollectionName string
+	Catalog        *Catalog
+	Safe           mgo.Safe
+	Mode           string
+	SessionReuse   string
+	dialInfo       *mgo.DialInfo
+	mode           mgo.Mode
+	opTimeout      time.Duration
+	conns          *connStats
+	ttlIndex       sync.Once
 }
 
+var readModes = map[string]mgo.Mode{
+	"primary":   mgo.Strong,
+	"monotonic": mgo.Monotonic,
+	"secondary": mgo.Eventual,
+}
+
+// Documents with expiry carry their expiration time in this field, which is
+// covered by a TTL index created on the first write with expiry.
+const ExpiryField = "expireAt"
+
 func (mongo *MongoDB) Init(config Config) {
-	dialInfo := &mgo.DialInfo{
+	tlsConfig, err := config.TLS.config()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Connections are dialed with the mgo default timeout, not the 5 s
+	// default of the other drivers.
+	mongo.conns = newConnStats(config.Client)
+	mongo.conns.Dialer.Timeout = config.Client.connectTimeout(10 * time.Minute)
+	mongo.dialInfo = &mgo.DialInfo{
 		Addrs:   config.Addresses,
-		Timeout: 10 * time.Minute,
+		Timeout: mongo.conns.Dialer.Timeout,
+		DialServer: func(addr *mgo.ServerAddr) (net.Conn, error) {
+			conn, err := mongo.conns.Dial("tcp", addr.TCPAddr().String())
+			if err != nil || tlsConfig == nil {
+				return conn, err
+			}
+			return tlsClient(conn, tlsConfig, addr.String())
+		},
+	}
+	if config.Username != "" {
+		mongo.dialInfo.Username = config.Username
+		mongo.dialInfo.Password = config.Password
+		mongo.dialInfo.Mechanism = "SCRAM-SHA-1"
 	}
+	mongo.SessionReuse = config.Client.sessionReuse("MongoDB",
+		PooledSessions, SharedSessions, NoSessionReuse)
 
-	var err error
-	mongo.Session, err = mgo.DialWithInfo(dialInfo)
-	if err != nil {
+	mongo.Mode = config.Durability.Read
+	if mongo.Mode == "" {
+		mongo.Mode = "monotonic"
+	}
+	mode, ok := readModes[mongo.Mode]
+	if !ok {
+		log.Fatalf("Unknown read mode: %s", mongo.Mode)
+	}
+
+	switch write := config.Durability.Write; {
+	case write == "":
+		mongo.Safe.W = 1
+	case write == "majority":
+		mongo.Safe.WMode = write
+	default:
+		if mongo.Safe.W, err = strconv.Atoi(write); err != nil {
+			log.Fatalf("Unknown write concern: %s", write)
+		}
+	}
+	mongo.Safe.J = config.Durability.Journal
+
+	if config.Client.PoolSize > 0 {
+		mongo.dialInfo.PoolLimit = config.Client.PoolSize
+	}
+	mongo.mode = mode
+	mongo.opTimeout = config.Client.opTimeout(0)
+	if mongo.Session, err = mongo.dial(); err != nil {
 		log.Fatal(err)
 	}
-	mongo.Session.SetMode(mgo.Monotonic, true)
 	mongo.DBName = config.Name
 	mongo.CollectionName = config.Table
+	mongo.Catalog = config.queryCatalog()
 }
 
 func (mongo *MongoDB) Shutdown() {
 	mongo.Session.Close()
 }
 
-func (mongo *MongoDB) Create(key string, value map[string]interface{}) error {
-	session := mongo.Session.New()
-	defer session.Close()
-	collection := session.DB(mongo.DBName).C(mongo.CollectionName)
+func (mongo *MongoDB) dial() (*mgo.Session, error) {
+	session, err := mgo.DialWithInfo(mongo.dialInfo)
+	if err != nil {
+		return nil, err
+	}
+	session.SetMode(mongo.mode, true)
+	session.SetSafe(&mongo.Safe)
+	if mongo.opTimeout > 0 {
+		session.SetSocketTimeout(mongo.opTimeout)
+		session.SetSyncTimeout(mongo.opTimeout)
+	}
+	return session, nil
+}
 
-	value["_id"] = key
-	err := collection.Insert(bson.M(value))
-	if !mgo.IsDup(err) {
-		return err
+// collection returns the collection in a session chosen by the session reuse
+// policy and a function releasing the session.
+func (mongo *MongoDB) collection() (*mgo.Collection, func(), error) {
+	var session *mgo.Session
+	switch mongo.SessionReuse {
+	case SharedSessions:
+		return mongo.Session.DB(mongo.DBName).C(mongo.CollectionName), func() {}, nil
+	case NoSessionReuse:
+		var err error
+		if session, err = mongo.dial(); err != nil {
+			return nil, nil, classify(Connection, err)
+		}
+	default:
+		session = mongo.Session.New()
+	}
+	return session.DB(mongo.DBName).C(mongo.CollectionName), session.Close, nil
+}
+
+func (mongo *MongoDB) ReportSummary() {
+	mongo.conns.ReportSummary()
+}
+
+func (mongo *MongoDB) Durability() string {
+	write := mongo.Safe.WMode
+	if write == "" {
+		write = strconv.Itoa(mongo.Safe.W)
+	}
+	if mongo.Safe.J {
+		write += " (journaled)"
+	}
+	return fmt.Sprintf("writes: w=%s; reads: %s", write, mongo.Mode)
+}
+
+func document(key string, value interface{}, expiry int) bson.M {
+	var doc bson.M
+	if raw, ok := value.([]byte); ok {
+		doc = bson.M{"value": bson.Binary{Kind: 0x00, Data: raw}}
 	} else {
+		doc = bson.M(value.(map[string]interface{}))
+	}
+	doc["_id"] = key
+	if expiry > 0 {
+		doc[ExpiryField] = time.Now().Add(time.Duration(expiry) * time.Second)
+	}
+	return doc
+}
+
+// ensureTTLIndex creates the TTL index. mgo does not allow zero
+// expireAfterSeconds, so documents live at most one second past their expiry
+// (in addition to the TTL monitor period of the server).
+func (mongo *MongoDB) ensureTTLIndex(collection *mgo.Collection) {
+	mongo.ttlIndex.Do(func() {
+		index := mgo.Index{
+			Key:         []string{ExpiryField},
+			ExpireAfter: time.Second,
+			Background:  true,
+		}
+		if err := collection.EnsureIndex(index); err != nil {
+			log.Fatal(err)
+		}
+	})
+}
+
+func mongoError(err error) error {
+	if err == nil {
 		return nil
 	}
+	if err == mgo.ErrNotFound {
+		return classify(NotFound, err)
+	}
+	if mgo.IsDup(err) {
+		return classify(AlreadyExists, err)
+	}
+	code := 0
+	switch e := err.(type) {
+	case *mgo.LastError:
+		code = e.Code
+	case *mgo.QueryError:
+		code = e.Code
+	}
+	switch {
+	case code == 50:
+		return classify(Timeout, err)
+	case code == 10107 || code == 13435 || code == 13436 || code == 11600 || code == 11602:
+		return classify(Temporary, err)
+	case strings.Contains(err.Error(), "no reachable servers"):
+		return classify(Connection, err)
+	case strings.Contains(err.Error(), "not master"):
+		return classify(Temporary, err)
+	}
+	return classify(ServerError, err)
 }
 
-func (mongo *MongoDB) Read(key string) error {
-	session := mongo.Session.New()
-	defer session.Close()
-	collection := session.DB(mongo.DBName).C(mongo.CollectionName)
+func (mongo *MongoDB) Create(key string, value interface{}, expiry int) error {
+	collection, release, err := mongo.collection()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if expiry > 0 {
+		mongo.ensureTTLIndex(collection)
+	}
+	return mongoError(collection.Insert(document(key, value, expiry)))
+}
+
+func (mongo *MongoDB) Read(key string) (interface{}, error) {
+	collection, release, err := mongo.collection()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
 	result := map[string]interface{}{}
-	err := collection.FindId(key).One(&result)
-	return err
+	err = collection.FindId(key).One(&result)
+	if err != nil {
+		return nil, mongoError(err)
+	}
+	delete(result, "_id")
+	delete(result, ExpiryField)
+	if raw, ok := result["value"].([]byte); ok && len(result) == 1 {
+		return raw, nil
+	}
+	return result, nil
 }
 
-func (mongo *MongoDB) Update(key string, value map[string]interface{}) error {
-	session := mongo.Session.New()
-	defer session.Close()
-	collection := session.DB(mongo.DBName).C(mongo.CollectionName)
+func (mongo *MongoDB) Update(key string, value interface{}, expiry int) error {
+	collection, release, err := mongo.collection()
+	if err != nil {
+		return err
+	}
+	defer release()
 
-	err := collection.Update(bson.M{"_id": key}, bson.M(value))
-	return err
+	if expiry > 0 {
+		mongo.ensureTTLIndex(collection)
+	}
+	err = collection.Update(bson.M{"_id": key}, document(key, value, expiry))
+	return mongoError(err)
 }
 
 func (mongo *MongoDB) Delete(key string) error {
-	session := mongo.Session.New()
-	defer session.Close()
-	collection := session.DB(mongo.DBName).C(mongo.CollectionName)
+	collection, release, err := mongo.collection()
+	if err != nil {
+		return err
+	}
+	defer release()
 
-	err := collection.Remove(bson.M{"_id": key})
-	return err
+	err = collection.Remove(bson.M{"_id": key})
+	return mongoError(err)
 }
 
-func (mongo *MongoDB) Query(key string, args []interface{}) error {
-	index := args[0].(string)
+func (mongo *MongoDB) Query(key string, args []interface{}) ([]map[string]interface{}, error) {
+	q, values, err := mongo.Catalog.Prepare(args)
+	if err != nil {
+		return nil, err
+	}
+	m := q.Mongo
+	if m == nil {
+		return nil, &Error{ServerError, fmt.Errorf("no MongoDB query for %s", q.Name)}
+	}
 
-	session := mongo.Session.New()
-	defer session.Close()
-	collection := session.DB(mongo.DBName).C(mongo.CollectionName)
+	collection, release, err := mongo.collection()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
 
-	var q, s bson.M
-	var d string
-	var pipe *mgo.Pipe
-	switch index {
-	case "name_and_street_by_city":
-		q = bson.M{
-			"city.f.f": args[1],
-		}
-		s = bson.M{
-			"name.f.f.f": 1,
-			"street.f.f": 1,
-		}
-	case "name_and_email_by_county":
-		q = bson.M{
-			"county.f.f": args[1],
-		}
-		s = bson.M{
-			"name.f.f.f": 1,
-			"email.f.f":  1,
-		}
-	case "achievements_by_realm":
-		q = bson.M{
-			"realm.f": args[1],
-		}
-		s = bson.M{
-			"achievements": 1,
-		}
-	case "name_by_coins":
-		q = bson.M{
-			"coins.f": bson.M{
-				"$gt": args[1].(float64) * 0.5,
-				"$lt": args[1].(float64),
-			},
-		}
-		s = bson.M{
-			"name.f.f.f": 1,
-		}
-	case "email_by_achievement_and_category":
-		q = bson.M{
-			"category": args[2].(int16),
-			"achievements.0": bson.M{
-				"$gt": 0,
-				"$lt": args[1].([]int16)[0] + 2,
-			},
-		}
-		s = bson.M{
-			"email.f.f": 1,
-		}
-	case "street_by_year_and_coins":
-		q = bson.M{
-			"year": args[1],
-			"coins.f": bson.M{
-				"$gt": args[2].(float64),
-				"$lt": 655.35,
-			},
-		}
-		s = bson.M{
-			"street.f.f": 1,
-		}
-	case "name_and_email_and_street_and_achievements_and_coins_by_city":
-		q = bson.M{
-			"city.f.f": args[1],
-		}
-		s = bson.M{
-			"name.f.f.f":   1,
-			"email.f.f":    1,
-			"street.f.f":   1,
-			"achievements": 1,
-			"coins.f":      1,
-		}
-	case "street_and_name_and_email_and_achievement_and_coins_by_county":
-		q = bson.M{
-			"county.f.f": args[1],
-		}
-		s = bson.M{
-			"street.f.f":   1,
-			"name.f.f.f":   1,
-			"email.f.f":    1,
-			"achievements": bson.M{"$slice": 1},
-			"coins.f":      1,
+	result := []map[string]interface{}{}
+	switch {
+	case m.Distinct != "":
+		distinct := []interface{}{}
+		err = collection.Find(bson.M{}).Distinct(m.Distinct, &distinct)
+		for _, value := range distinct {
+			result = append(result, map[string]interface{}{"value": value})
 		}
-	case "category_name_and_email_and_street_and_gmtime_and_year_by_country":
-		q = bson.M{
-			"country.f": args[1],
+	case m.Pipeline != nil:
+		err = collection.Pipe(Render(m.Pipeline, values)).All(&result)
+	default:
+		err = collection.Find(Render(m.Find, values)).Select(m.Select).
+			Limit(mongo.Catalog.Limit).All(&result)
+	}
+
+	return result, mongoError(err)
+}
+
+func (mongo *MongoDB) indexKeys(indexes []string) ([][]string, error) {
+	if err := mongo.Catalog.Check("MongoDB", indexes); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	result := [][]string{}
+	for _, index := range indexes {
+		q, _ := mongo.Catalog.Get(index)
+		name := strings.Join(q.Mongo.Index, ",")
+		if len(q.Mongo.Index) > 0 && !seen[name] {
+			seen[name] = true
+			result = append(result, q.Mongo.Index)
 		}
-		s = bson.M{
-			"category":   1,
-			"name.f.f.f": 1,
-			"email.f.f":  1,
-			"street.f.f": 1,
-			"gmtime":     1,
-			"year":       1,
+	}
+	return result, nil
+}
+
+// Setup creates indexes for the given query indexes.
+func (mongo *MongoDB) Setup(indexes []string) error {
+	keys, err := mongo.indexKeys(indexes)
+	if err != nil {
+		return err
+	}
+	collection := mongo.Session.DB(mongo.DBName).C(mongo.CollectionName)
+	for _, key := range keys {
+		if err := collection.EnsureIndex(mgo.Index{Key: key, Background: true}); err != nil {
+			return err
 		}
-	case "body_by_city":
-		q = bson.M{
-			"city.f.f": args[1],
+	}
+	return nil
+}
+
+// Teardown drops indexes created by Setup, missing indexes are skipped.
+func (mongo *MongoDB) Teardown(indexes []string) error {
+	keys, err := mongo.indexKeys(indexes)
+	if err != nil {
+		return err
+	}
+	collection := mongo.Session.DB(mongo.DBName).C(mongo.CollectionName)
+	for _, key := range keys {
+		if err := ignoreSchemaError(collection.DropIndex(key...), "index not found"); err != nil {
+			return err
 		}
-		s = bson.M{
-			"body": 1,
+	}
+	return nil
+}
+
+// Scan reads documents in _id order.
+func (mongo *MongoDB) Scan(startKey string, options ScanOptions) (int, error) {
+	collection, release, err := mongo.collection()
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	query := bson.M{"_id": bson.M{"$gte": startKey}}
+	scanned := 0
+	if options.Pagination == CursorPagination {
+		iter := collection.Find(query).Sort("_id").Batch(options.pageLimit(0)).
+			Limit(options.Length).Iter()
+		doc := bson.M{}
+		for iter.Next(&doc) {
+			scanned++
 		}
-	case "body_by_realm":
-		q = bson.M{
-			"realm.f": args[1],
+		return scanned, mongoError(iter.Close())
+	}
+	for scanned < options.Length {
+		limit := options.pageLimit(scanned)
+		q := collection.Find(query).Sort("_id").Limit(limit)
+		if options.Pagination != KeysetPagination {
+			q = q.Skip(scanned)
 		}
-		s = bson.M{
-			"body": 1,
+		docs := []bson.M{}
+		if err := q.All(&docs); err != nil {
+			return scanned, mongoError(err)
 		}
-	case "body_by_country":
-		q = bson.M{
-			"country.f": args[1],
+		scanned += len(docs)
+		if len(docs) < limit {
+			break
 		}
-		s = bson.M{
-			"body": 1,
+		if options.Pagination == KeysetPagination {
+			query = bson.M{"_id": bson.M{"$gt": docs[len(docs)-1]["_id"]}}
 		}
-	case "distinct_states":
-		d = args[1].(string)
-	case "distinct_full_states":
-		d = args[1].(string)
-	case "distinct_years":
-		d = args[1].(string)
-	case "coins_stats_by_state_and_year":
-		pipe = collection.Pipe(
-			[]bson.M{
-				{
-					"$match": bson.M{
-						"state.f": args[1],
-						"year":    args[2],
-					},
-				},
-				{
-					"$group": bson.M{
-						"_id": bson.M{
-							"state": "$state.f",
-							"year":  "$year",
-						},
-						"count": bson.M{"$sum": 1},
-						"sum":   bson.M{"$sum": "$coins.f"},
-						"avg":   bson.M{"$avg": "$coins.f"},
-						"min":   bson.M{"$min": "$coins.f"},
-						"max":   bson.M{"$max": "$coins.f"},
-					},
-				},
-			},
-		)
-	case "coins_stats_by_gmtime_and_year":
-		pipe = collection.Pipe(
-			[]bson.M{
-				{
-					"$match": bson.M{
-						"gmtime": args[1],
-						"year":   args[2],
-					},
-				},
-				{
-					"$group": bson.M{
-						"_id": bson.M{
-							"gmtime": "$gmtime",
-							"year":   "$year",
-						},
-						"count": bson.M{"$sum": 1},
-						"sum":   bson.M{"$sum": "$coins.f"},
-						"avg":   bson.M{"$avg": "$coins.f"},
-						"min":   bson.M{"$min": "$coins.f"},
-						"max":   bson.M{"$max": "$coins.f"},
-					},
-				},
-			},
-		)
-	case "coins_stats_by_full_state_and_year":
-		pipe = collection.Pipe(
-			[]bson.M{
-				{
-					"$match": bson.M{
-						"full_state.f": args[1],
-						"year":         args[2],
-					},
-				},
-				{
-					"$group": bson.M{
-						"_id": bson.M{
-							"year":       "$year",
-							"full_state": "$full_state.f",
-						},
-						"count": bson.M{"$sum": 1},
-						"sum":   bson.M{"$sum": "$coins.f"},
-						"avg":   bson.M{"$avg": "$coins.f"},
-						"min":   bson.M{"$min": "$coins.f"},
-						"max":   bson.M{"$max": "$coins.f"},
-					},
-				},
-			},
-		)
-	}
-
-	var err error
-	result := []map[string]interface{}{}
-	if len(q) != 0 {
-		err = collection.Find(q).Select(s).Limit(20).All(&result)
-	} else if len(d) != 0 {
-		err = collection.Find(bson.M{}).Distinct(d, &result)
-	} else {
-		err = pipe.All(&result)
 	}
-	session.Close()
-
-	return err
+	return scanned, nil
 }
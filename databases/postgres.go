@@ -0,0 +1,419 @@
+package databases
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PostgreSQL stores documents in a JSONB column and binary payloads in a bytea
+// column of a table keyed by the blurr key. Expired rows are not returned by
+// Read, but they stay in the table and are visible to queries.
+type PostgreSQL struct {
+	DB         *sql.DB
+	Table      string
+	Catalog    *Catalog
+	conns      *connStats
+	timeout    time.Duration
+	durability string
+}
+
+const postgresSchema = `
+	CREATE TABLE IF NOT EXISTS %s (
+		key text PRIMARY KEY,
+		doc jsonb,
+		raw bytea,
+		expires_at timestamptz
+	)`
+
+var synchronousCommit = map[string]bool{
+	"on": true, "off": true, "local": true, "remote_write": true, "remote_apply": true,
+}
+
+// dataSource returns the connection URL, TLS files are passed to the driver.
+func dataSource(config Config) string {
+	u := &url.URL{Scheme: "postgres", Host: config.Addresses[0], Path: "/" + config.Name}
+	if config.Username != "" {
+		u.User = url.UserPassword(config.Username, config.Password)
+	}
+	params := url.Values{}
+	switch {
+	case !config.TLS.Enabled:
+		params.Set("sslmode", "disable")
+	case config.TLS.SkipVerify:
+		params.Set("sslmode", "require")
+	default:
+		params.Set("sslmode", "verify-full")
+	}
+	for param, file := range map[string]string{
+		"sslrootcert": config.TLS.CAFile,
+		"sslcert":     config.TLS.CertFile,
+		"sslkey":      config.TLS.KeyFile,
+	} {
+		if file != "" {
+			params.Set(param, file)
+		}
+	}
+	if config.Durability.Write != "" {
+		params.Set("synchronous_commit", config.Durability.Write)
+	}
+	u.RawQuery = params.Encode()
+	return u.String()
+}
+
+func (p *PostgreSQL) Init(config Config) {
+	p.durability = config.Durability.Write
+	if p.durability == "" {
+		p.durability = "on"
+	}
+	if !synchronousCommit[p.durability] {
+		log.Fatalf("Unknown synchronous commit level: %s", p.durability)
+	}
+	p.Table = config.Table
+	p.timeout = config.Client.opTimeout(0)
+
+	connector, err := pq.NewConnector(dataSource(config))
+	if err != nil {
+		log.Fatal(err)
+	}
+	p.conns = newConnStats(config.Client)
+	connector.Dialer(p.conns)
+	p.DB = sql.OpenDB(connector)
+	if config.Client.PoolSize > 0 {
+		p.DB.SetMaxOpenConns(config.Client.PoolSize)
+		p.DB.SetMaxIdleConns(config.Client.PoolSize)
+	}
+	if config.Client.sessionReuse("PostgreSQL", PooledSessions, NoSessionReuse) == NoSessionReuse {
+		p.DB.SetMaxIdleConns(-1)
+	}
+	if err = p.DB.Ping(); err != nil {
+		log.Fatal(err)
+	}
+	p.Catalog = config.queryCatalog()
+}
+
+func (p *PostgreSQL) Shutdown() {
+	p.DB.Close()
+}
+
+func (p *PostgreSQL) Durability() string {
+	return fmt.Sprintf("writes: synchronous_commit=%s", p.durability)
+}
+
+func (p *PostgreSQL) ReportSummary() {
+	p.conns.ReportSummary()
+}
+
+// context bounds operations by the operation timeout.
+func (p *PostgreSQL) context() (context.Context, context.CancelFunc) {
+	if p.timeout > 0 {
+		return context.WithTimeout(context.Background(), p.timeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+func postgresError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(*pq.Error); ok {
+		switch {
+		case e.Code == "23505":
+			return classify(AlreadyExists, err)
+		case e.Code == "57014":
+			return classify(Timeout, err)
+		case e.Code == "40001" || e.Code == "40P01" || e.Code == "53300" || e.Code == "57P03":
+			return classify(Temporary, err)
+		case e.Code.Class() == "08":
+			return classify(Connection, err)
+		}
+		return classify(ServerError, err)
+	}
+	switch err {
+	case sql.ErrNoRows:
+		return classify(NotFound, err)
+	case context.DeadlineExceeded:
+		return classify(Timeout, err)
+	case driver.ErrBadConn:
+		return classify(Connection, err)
+	}
+	if _, ok := err.(net.Error); ok {
+		return classify(Connection, err)
+	}
+	return classify(ServerError, err)
+}
+
+// row returns the doc, raw and expires_at values of a value.
+func row(value interface{}, expiry int) (doc, raw, expiresAt interface{}, err error) {
+	if expiry > 0 {
+		expiresAt = time.Now().Add(time.Duration(expiry) * time.Second)
+	}
+	if data, ok := value.([]byte); ok {
+		return nil, data, expiresAt, nil
+	}
+	encoded, err := json.Marshal(value)
+	return encoded, nil, expiresAt, err
+}
+
+func (p *PostgreSQL) exec(statement string, args ...interface{}) (int64, error) {
+	ctx, cancel := p.context()
+	defer cancel()
+	result, err := p.DB.ExecContext(ctx, statement, args...)
+	if err != nil {
+		return 0, postgresError(err)
+	}
+	affected, err := result.RowsAffected()
+	return affected, postgresError(err)
+}
+
+// Create replaces expired rows, a row which has not expired is reported as
+// AlreadyExists.
+func (p *PostgreSQL) Create(key string, value interface{}, expiry int) error {
+	doc, raw, expiresAt, err := row(value, expiry)
+	if err != nil {
+		return classify(ServerError, err)
+	}
+	created, err := p.exec(fmt.Sprintf("INSERT INTO %[1]s (key, doc, raw, expires_at) VALUES ($1, $2, $3, $4) "+
+		"ON CONFLICT (key) DO UPDATE SET doc = $2, raw = $3, expires_at = $4 WHERE %[1]s.expires_at <= now()",
+		p.Table), key, doc, raw, expiresAt)
+	if err == nil && created == 0 {
+		return &Error{AlreadyExists, fmt.Errorf("key %s already exists", key)}
+	}
+	return err
+}
+
+func (p *PostgreSQL) Read(key string) (interface{}, error) {
+	ctx, cancel := p.context()
+	defer cancel()
+	var doc, raw []byte
+	err := p.DB.QueryRowContext(ctx, fmt.Sprintf("SELECT doc, raw FROM %s "+
+		"WHERE key = $1 AND (expires_at IS NULL OR expires_at > now())", p.Table), key).Scan(&doc, &raw)
+	if err != nil {
+		return nil, postgresError(err)
+	}
+	if doc == nil {
+		return raw, nil
+	}
+	result := map[string]interface{}{}
+	err = json.Unmarshal(doc, &result)
+	return result, classify(ServerError, err)
+}
+
+func (p *PostgreSQL) Update(key string, value interface{}, expiry int) error {
+	doc, raw, expiresAt, err := row(value, expiry)
+	if err != nil {
+		return classify(ServerError, err)
+	}
+	updated, err := p.exec(fmt.Sprintf("UPDATE %s SET doc = $2, raw = $3, expires_at = $4 WHERE key = $1",
+		p.Table), key, doc, raw, expiresAt)
+	if err == nil && updated == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (p *PostgreSQL) Delete(key string) error {
+	deleted, err := p.exec(fmt.Sprintf("DELETE FROM %s WHERE key = $1", p.Table), key)
+	if err == nil && deleted == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+// sqlArg binds documents fields which are not scalars as JSON.
+func sqlArg(value interface{}) interface{} {
+	switch value.(type) {
+	case []int16, []interface{}, map[string]interface{}:
+		encoded, _ := json.Marshal(value)
+		return string(encoded)
+	}
+	return value
+}
+
+// scanRows converts result rows to maps, JSON columns are decoded.
+func scanRows(rows *sql.Rows) ([]map[string]interface{}, error) {
+	columns, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	result := []map[string]interface{}{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		r := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			value := values[i]
+			if data, ok := value.([]byte); ok {
+				switch column.DatabaseTypeName() {
+				case "JSON", "JSONB":
+					err = json.Unmarshal(data, &value)
+				case "NUMERIC":
+					value, err = strconv.ParseFloat(string(data), 64)
+				default:
+					value = string(data)
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+			r[column.Name()] = value
+		}
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
+
+// Query runs the SQL statement of the catalog query.
+func (p *PostgreSQL) Query(key string, args []interface{}) ([]map[string]interface{}, error) {
+	q, values, err := p.Catalog.Prepare(args)
+	if err != nil {
+		return nil, err
+	}
+	if q.SQL == nil {
+		return nil, &Error{ServerError, fmt.Errorf("no SQL statement for query %s", q.Name)}
+	}
+	values["table"] = p.Table
+	statement, bind, err := q.SQLStatement(values)
+	if err != nil {
+		return nil, err
+	}
+	for i, arg := range bind {
+		bind[i] = sqlArg(arg)
+	}
+
+	ctx, cancel := p.context()
+	defer cancel()
+	rows, err := p.DB.QueryContext(ctx, statement, bind...)
+	if err != nil {
+		return nil, postgresError(err)
+	}
+	defer rows.Close()
+	result, err := scanRows(rows)
+	return result, postgresError(err)
+}
+
+// indexName derives index names from expressions, which may exceed the
+// identifier length limit.
+func (p *PostgreSQL) indexName(expressions []string) string {
+	h := fnv.New32a()
+	h.Write([]byte(strings.Join(expressions, ",")))
+	return fmt.Sprintf("%s_%08x", p.Table, h.Sum32())
+}
+
+func (p *PostgreSQL) indexes(indexes []string) ([][]string, error) {
+	if err := p.Catalog.Check("PostgreSQL", indexes); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	result := [][]string{}
+	for _, index := range indexes {
+		q, _ := p.Catalog.Get(index)
+		name := p.indexName(q.SQL.Index)
+		if len(q.SQL.Index) > 0 && !seen[name] {
+			seen[name] = true
+			result = append(result, q.SQL.Index)
+		}
+	}
+	return result, nil
+}
+
+// Setup creates the table and expression indexes for the given queries.
+func (p *PostgreSQL) Setup(indexes []string) error {
+	expressions, err := p.indexes(indexes)
+	if err != nil {
+		return err
+	}
+	statements := []string{fmt.Sprintf(postgresSchema, p.Table)}
+	for _, index := range expressions {
+		statements = append(statements, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s ((%s))",
+			p.indexName(index), p.Table, strings.Join(index, "), (")))
+	}
+	for _, statement := range statements {
+		if _, err := p.DB.Exec(statement); err != nil {
+			return fmt.Errorf("%s: %v", statement, err)
+		}
+	}
+	return nil
+}
+
+// Teardown drops the table with its indexes.
+func (p *PostgreSQL) Teardown(indexes []string) error {
+	_, err := p.DB.Exec(fmt.Sprintf("DROP TABLE IF EXISTS %s", p.Table))
+	return err
+}
+
+// Scan reads documents in key order.
+func (p *PostgreSQL) Scan(startKey string, options ScanOptions) (int, error) {
+	ctx, cancel := p.context()
+	defer cancel()
+	scanned := 0
+	if options.Pagination == CursorPagination {
+		rows, err := p.DB.QueryContext(ctx, fmt.Sprintf("SELECT doc, raw FROM %s WHERE key >= $1 "+
+			"ORDER BY key LIMIT $2", p.Table), startKey, options.Length)
+		if err != nil {
+			return 0, postgresError(err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			scanned++
+		}
+		return scanned, postgresError(rows.Err())
+	}
+
+	last := startKey
+	for scanned < options.Length {
+		limit := options.pageLimit(scanned)
+		var rows *sql.Rows
+		var err error
+		if options.Pagination == KeysetPagination {
+			op := ">"
+			if scanned == 0 {
+				op = ">="
+			}
+			rows, err = p.DB.QueryContext(ctx, fmt.Sprintf("SELECT key, doc, raw FROM %s WHERE key %s $1 "+
+				"ORDER BY key LIMIT $2", p.Table, op), last, limit)
+		} else {
+			rows, err = p.DB.QueryContext(ctx, fmt.Sprintf("SELECT key, doc, raw FROM %s WHERE key >= $1 "+
+				"ORDER BY key LIMIT $2 OFFSET $3", p.Table), startKey, limit, scanned)
+		}
+		if err != nil {
+			return scanned, postgresError(err)
+		}
+		page := 0
+		for rows.Next() {
+			var doc, raw []byte
+			if err := rows.Scan(&last, &doc, &raw); err != nil {
+				rows.Close()
+				return scanned, postgresError(err)
+			}
+			page++
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return scanned, postgresError(err)
+		}
+		scanned += page
+		if page < limit {
+			break
+		}
+	}
+	return scanned, nil
+}
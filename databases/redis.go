@@ -0,0 +1,545 @@
+package databases
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+type RedisConfig struct {
+	Encoding string
+	Pipeline int
+	Database int
+}
+
+// Document encodings: String stores documents as JSON values, Hash stores
+// every top-level field as a JSON-encoded hash field, along with searchable
+// fields of N1QL workload documents.
+const (
+	StringEncoding = "String"
+	HashEncoding   = "Hash"
+)
+
+// Redis stores values under their keys. With Config.Redis.Pipeline greater
+// than 1 concurrent operations are coalesced into pipelines of up to that many
+// operations, every pipeline is sent on a single pooled connection.
+type Redis struct {
+	Pool     *redis.Pool
+	Index    string
+	Catalog  *Catalog
+	config   RedisConfig
+	conns    *connStats
+	replicas int
+	timeout  time.Duration
+	requests chan *redisRequest
+	workers  sync.WaitGroup
+}
+
+type redisCommand []interface{}
+
+type redisRequest struct {
+	commands []redisCommand
+	reply    chan redisReply
+}
+
+type redisReply struct {
+	values []interface{}
+	err    error
+}
+
+// Searchable fields of N1QL workload documents, they are stored in hash
+// fields with the "q_" prefix and indexed by RediSearch.
+var redisFields = map[string]string{
+	"name":        "TAG",
+	"email":       "TAG",
+	"street":      "TAG",
+	"city":        "TAG",
+	"county":      "TAG",
+	"realm":       "TAG",
+	"country":     "TAG",
+	"state":       "TAG",
+	"full_state":  "TAG",
+	"coins":       "NUMERIC",
+	"category":    "NUMERIC",
+	"year":        "NUMERIC",
+	"achievement": "NUMERIC",
+}
+
+const redisFieldPrefix = "q_"
+
+// Binary payloads are stored in this field with Hash encoding.
+const redisRawField = "_raw"
+
+func (r *Redis) Init(config Config) {
+	if len(config.Addresses) != 1 {
+		log.Fatal("Redis driver supports a single address")
+	}
+	r.config = config.Redis
+	switch r.config.Encoding {
+	case "":
+		r.config.Encoding = StringEncoding
+	case StringEncoding, HashEncoding:
+	default:
+		log.Fatalf("Unknown encoding: %s", r.config.Encoding)
+	}
+	r.Index = config.Table
+	r.replicas = config.Durability.ReplicateTo
+	r.timeout = config.Client.opTimeout(0)
+
+	tlsConfig, err := config.TLS.config()
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.conns = newConnStats(config.Client)
+	options := []redis.DialOption{
+		redis.DialNetDial(r.conns.Dial),
+		redis.DialReadTimeout(r.timeout),
+		redis.DialWriteTimeout(r.timeout),
+		redis.DialDatabase(r.config.Database),
+	}
+	if config.Username != "" {
+		options = append(options, redis.DialUsername(config.Username))
+	}
+	if config.Password != "" {
+		options = append(options, redis.DialPassword(config.Password))
+	}
+	if tlsConfig != nil {
+		options = append(options, redis.DialUseTLS(true), redis.DialTLSConfig(tlsConfig))
+	}
+
+	poolSize := config.Client.poolSize(runtime.NumCPU())
+	r.Pool = &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", config.Addresses[0], options...)
+		},
+		MaxIdle:   poolSize,
+		MaxActive: poolSize,
+		Wait:      true,
+	}
+	if config.Client.sessionReuse("Redis", PooledSessions, NoSessionReuse) == NoSessionReuse {
+		r.Pool.MaxIdle = 0
+	}
+
+	conn := r.Pool.Get()
+	_, err = conn.Do("PING")
+	conn.Close()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if r.config.Pipeline > 1 {
+		r.requests = make(chan *redisRequest, poolSize*r.config.Pipeline)
+		r.workers.Add(poolSize)
+		for i := 0; i < poolSize; i++ {
+			go r.pipeline()
+		}
+	}
+	r.Catalog = config.queryCatalog()
+}
+
+// Shutdown stops pipeline workers once queued requests are sent, it must not
+// be called while operations are running.
+func (r *Redis) Shutdown() {
+	if r.requests != nil {
+		close(r.requests)
+		r.workers.Wait()
+	}
+	r.Pool.Close()
+}
+
+func (r *Redis) Durability() string {
+	return fmt.Sprintf("writes: replicate to %d", r.replicas)
+}
+
+func (r *Redis) ReportSummary() {
+	r.conns.ReportSummary()
+}
+
+func redisError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if err == redis.ErrNil {
+		return classify(NotFound, err)
+	}
+	if err == redis.ErrPoolExhausted {
+		return classify(Temporary, err)
+	}
+	if e, ok := err.(redis.Error); ok {
+		switch strings.SplitN(e.Error(), " ", 2)[0] {
+		case "LOADING", "BUSY", "TRYAGAIN", "MASTERDOWN", "OOM", "CLUSTERDOWN":
+			return classify(Temporary, err)
+		}
+		return classify(ServerError, err)
+	}
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return classify(Timeout, err)
+	}
+	if _, ok := err.(net.Error); ok || err == io.EOF {
+		return classify(Connection, err)
+	}
+	return classify(ServerError, err)
+}
+
+// do runs the commands on one connection and returns the reply of the last
+// one, or the first error.
+func (r *Redis) do(commands ...redisCommand) (interface{}, error) {
+	replies, err := r.exec(commands...)
+	if err != nil {
+		return nil, err
+	}
+	return replies[len(replies)-1], nil
+}
+
+// exec runs the commands on one connection and returns all replies, or the
+// first error.
+func (r *Redis) exec(commands ...redisCommand) ([]interface{}, error) {
+	if r.requests != nil {
+		request := &redisRequest{commands, make(chan redisReply, 1)}
+		r.requests <- request
+		reply := <-request.reply
+		return reply.values, reply.err
+	}
+	conn := r.Pool.Get()
+	defer conn.Close()
+	for _, command := range commands {
+		if err := conn.Send(command[0].(string), command[1:]...); err != nil {
+			return nil, err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+	return receive(conn, len(commands))
+}
+
+// receive reads n replies. Errors of commands in a transaction are elements of
+// the EXEC reply.
+func receive(conn redis.Conn, n int) (replies []interface{}, err error) {
+	for i := 0; i < n; i++ {
+		reply, e := conn.Receive()
+		if e == nil {
+			e = replyError(reply)
+		}
+		if e != nil && err == nil {
+			err = e
+		}
+		replies = append(replies, reply)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return replies, nil
+}
+
+func replyError(reply interface{}) error {
+	switch r := reply.(type) {
+	case redis.Error:
+		return r
+	case []interface{}:
+		for _, element := range r {
+			if e, ok := element.(redis.Error); ok {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+// pipeline sends queued requests in batches and dispatches the replies.
+func (r *Redis) pipeline() {
+	for request := range r.requests {
+		batch := []*redisRequest{request}
+	drain:
+		for len(batch) < r.config.Pipeline {
+			select {
+			case request := <-r.requests:
+				batch = append(batch, request)
+			default:
+				break drain
+			}
+		}
+
+		conn := r.Pool.Get()
+		var err error
+		for _, request := range batch {
+			for _, command := range request.commands {
+				if err == nil {
+					err = conn.Send(command[0].(string), command[1:]...)
+				}
+			}
+		}
+		if err == nil {
+			err = conn.Flush()
+		}
+		for _, request := range batch {
+			if err != nil {
+				request.reply <- redisReply{nil, err}
+				continue
+			}
+			replies, e := receive(conn, len(request.commands))
+			request.reply <- redisReply{replies, e}
+		}
+		conn.Close()
+	}
+	r.workers.Done()
+}
+
+// write runs write commands followed by WAIT when writes must be replicated,
+// and returns the reply of the first command.
+func (r *Redis) write(commands ...redisCommand) (interface{}, error) {
+	if r.replicas > 0 {
+		commands = append(commands, redisCommand{"WAIT", r.replicas, int64(r.timeout / time.Millisecond)})
+	}
+	replies, err := r.exec(commands...)
+	if err != nil {
+		return nil, redisError(err)
+	}
+	if r.replicas > 0 {
+		if replicated, _ := replies[len(replies)-1].(int64); int(replicated) < r.replicas {
+			return nil, &Error{Timeout, fmt.Errorf("replicated to %d of %d replicas", replicated, r.replicas)}
+		}
+	}
+	return replies[0], nil
+}
+
+// hashFields returns field-value pairs of the hash storing a value.
+func hashFields(value interface{}) ([]interface{}, error) {
+	if raw, ok := value.([]byte); ok {
+		return []interface{}{redisRawField, raw}, nil
+	}
+	doc := value.(map[string]interface{})
+	fields := []interface{}{}
+	for field, v := range doc {
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field, encoded)
+	}
+	names, values := typedColumns(doc, nil, nil)
+	for i, name := range names {
+		if _, ok := redisFields[name]; ok {
+			fields = append(fields, redisFieldPrefix+name, fmt.Sprint(values[i]))
+		}
+	}
+	return fields, nil
+}
+
+// decodeHash converts HGETALL and FT.SEARCH replies to values.
+func decodeHash(fields []interface{}) (interface{}, error) {
+	doc := map[string]interface{}{}
+	for i := 0; i+1 < len(fields); i += 2 {
+		name, _ := redis.String(fields[i], nil)
+		value, _ := redis.Bytes(fields[i+1], nil)
+		if name == redisRawField {
+			return value, nil
+		}
+		if strings.HasPrefix(name, redisFieldPrefix) {
+			continue
+		}
+		var v interface{}
+		if err := json.Unmarshal(value, &v); err != nil {
+			return nil, classify(ServerError, err)
+		}
+		doc[name] = v
+	}
+	return doc, nil
+}
+
+func (r *Redis) set(key string, value interface{}, expiry int) error {
+	if r.config.Encoding == StringEncoding {
+		data, ok := value.([]byte)
+		if !ok {
+			var err error
+			if data, err = json.Marshal(value); err != nil {
+				return classify(ServerError, err)
+			}
+		}
+		command := redisCommand{"SET", key, data}
+		if expiry > 0 {
+			command = append(command, "EX", expiry)
+		}
+		_, err := r.write(command)
+		return err
+	}
+
+	fields, err := hashFields(value)
+	if err != nil {
+		return classify(ServerError, err)
+	}
+	commands := []redisCommand{{"MULTI"}, {"DEL", key}, append(redisCommand{"HSET", key}, fields...)}
+	if expiry > 0 {
+		commands = append(commands, redisCommand{"EXPIRE", key, expiry})
+	}
+	_, err = r.write(append(commands, redisCommand{"EXEC"})...)
+	return err
+}
+
+func (r *Redis) Create(key string, value interface{}, expiry int) error {
+	return r.set(key, value, expiry)
+}
+
+func (r *Redis) Read(key string) (interface{}, error) {
+	if r.config.Encoding == StringEncoding {
+		data, err := redis.Bytes(r.do(redisCommand{"GET", key}))
+		if err != nil {
+			return nil, redisError(err)
+		}
+		return decodeRaw(data)
+	}
+	fields, err := redis.Values(r.do(redisCommand{"HGETALL", key}))
+	if err != nil {
+		return nil, redisError(err)
+	}
+	if len(fields) == 0 {
+		return nil, ErrNotFound
+	}
+	return decodeHash(fields)
+}
+
+func (r *Redis) Update(key string, value interface{}, expiry int) error {
+	return r.set(key, value, expiry)
+}
+
+func (r *Redis) Delete(key string) error {
+	reply, err := r.write(redisCommand{"DEL", key})
+	if err != nil {
+		return err
+	}
+	if deleted, _ := reply.(int64); deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// searchable reports whether the query can be derived from its expectation:
+// rows of documents matching a filter on searchable fields.
+func searchable(q *QueryDefinition) bool {
+	if q.Expect == nil || q.Expect.Kind != "rows" {
+		return false
+	}
+	for _, condition := range q.Expect.Filter {
+		kind, ok := redisFields[condition.Field]
+		if !ok || kind == "TAG" && condition.Op != "=" {
+			return false
+		}
+	}
+	return true
+}
+
+func escapeTag(value string) string {
+	var escaped strings.Builder
+	for _, c := range value {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(c)
+	}
+	return escaped.String()
+}
+
+// searchQuery translates the filter of the expectation to a RediSearch query.
+func searchQuery(e *Expectation, values map[string]interface{}) string {
+	terms := []string{}
+	for _, condition := range e.Filter {
+		value := condition.Value
+		if condition.Param != "" {
+			value = values[condition.Param]
+		}
+		field := "@" + redisFieldPrefix + condition.Field
+		if redisFields[condition.Field] == "TAG" {
+			terms = append(terms, fmt.Sprintf("%s:{%s}", field, escapeTag(fmt.Sprint(value))))
+			continue
+		}
+		var min, max string
+		switch condition.Op {
+		case "=":
+			min, max = fmt.Sprint(value), fmt.Sprint(value)
+		case "<":
+			min, max = "-inf", fmt.Sprintf("(%v", value)
+		case "<=":
+			min, max = "-inf", fmt.Sprint(value)
+		case ">":
+			min, max = fmt.Sprintf("(%v", value), "+inf"
+		case ">=":
+			min, max = fmt.Sprint(value), "+inf"
+		}
+		terms = append(terms, fmt.Sprintf("%s:[%s %s]", field, min, max))
+	}
+	if len(terms) == 0 {
+		return "*"
+	}
+	return strings.Join(terms, " ")
+}
+
+// Query runs a RediSearch query derived from the expectation of the catalog
+// query, which requires Hash encoding.
+func (r *Redis) Query(key string, args []interface{}) ([]map[string]interface{}, error) {
+	q, values, err := r.Catalog.Prepare(args)
+	if err != nil {
+		return nil, err
+	}
+	if r.config.Encoding != HashEncoding || !searchable(q) {
+		return nil, &Error{ServerError, fmt.Errorf("query %s is not supported", q.Name)}
+	}
+	reply, err := redis.Values(r.do(redisCommand{"FT.SEARCH", r.Index,
+		searchQuery(q.Expect, values), "LIMIT", 0, r.Catalog.Limit}))
+	if err != nil {
+		return nil, redisError(err)
+	}
+
+	rows := []map[string]interface{}{}
+	for i := 2; i < len(reply); i += 2 {
+		fields, _ := redis.Values(reply[i], nil)
+		doc, err := decodeHash(fields)
+		if err != nil {
+			return nil, err
+		}
+		if row, ok := doc.(map[string]interface{}); ok {
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+// Setup creates the RediSearch index over the fields used by the given
+// queries.
+func (r *Redis) Setup(indexes []string) error {
+	if err := r.Catalog.Check("Redis", indexes); err != nil {
+		return err
+	}
+	if r.config.Encoding != HashEncoding {
+		return fmt.Errorf("queries require %s encoding", HashEncoding)
+	}
+	schema := redisCommand{"FT.CREATE", r.Index, "ON", "HASH", "SCHEMA"}
+	seen := map[string]bool{}
+	for _, index := range indexes {
+		q, _ := r.Catalog.Get(index)
+		for _, condition := range q.Expect.Filter {
+			if !seen[condition.Field] {
+				seen[condition.Field] = true
+				schema = append(schema, redisFieldPrefix+condition.Field, redisFields[condition.Field])
+			}
+		}
+	}
+	if len(seen) == 0 {
+		return nil
+	}
+	_, err := r.do(schema)
+	return ignoreSchemaError(err, "index already exists")
+}
+
+// Teardown drops the RediSearch index, documents are kept.
+func (r *Redis) Teardown(indexes []string) error {
+	_, err := r.do(redisCommand{"FT.DROPINDEX", r.Index})
+	return ignoreSchemaError(err, "unknown index")
+}
@@ -0,0 +1,84 @@
+package databases
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestSearchQuery(t *testing.T) {
+	catalog, err := LoadCatalog("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, _ := catalog.Get("name_and_email_by_county")
+	if query := searchQuery(q.Expect, map[string]interface{}{"county": "a1@b.c"}); query != `@q_county:{a1\@b\.c}` {
+		t.Errorf("unexpected query: %s", query)
+	}
+	q, _ = catalog.Get("street_by_year_and_coins")
+	query := searchQuery(q.Expect, map[string]interface{}{"year": int16(1989), "coins": 1.5})
+	if query != "@q_year:[1989 1989] @q_coins:[(1.5 +inf] @q_coins:[-inf (655.35]" {
+		t.Errorf("unexpected query: %s", query)
+	}
+	if err := catalog.Check("Redis", []string{"distinct_years"}); err == nil {
+		t.Error("distinct query accepted")
+	}
+}
+
+func TestHashFields(t *testing.T) {
+	doc := map[string]interface{}{
+		"city":         map[string]interface{}{"f": map[string]interface{}{"f": "90ac48"}},
+		"achievements": []int16{3, 1},
+	}
+	fields, err := hashFields(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replies := []interface{}{}
+	indexed := map[string]interface{}{}
+	for i := 0; i < len(fields); i += 2 {
+		replies = append(replies, []byte(fields[i].(string)), fields[i+1])
+		indexed[fields[i].(string)] = fields[i+1]
+	}
+	if indexed["q_city"] != "90ac48" || indexed["q_achievement"] != "3" {
+		t.Errorf("unexpected search fields: %v", indexed)
+	}
+	decoded, err := decodeHash(replies)
+	expected := map[string]interface{}{
+		"city":         map[string]interface{}{"f": map[string]interface{}{"f": "90ac48"}},
+		"achievements": []interface{}{3.0, 1.0},
+	}
+	if err != nil || !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("%v != %v (%v)", decoded, expected, err)
+	}
+}
+
+// TestRedis runs against the server at BLURR_REDIS_ADDRESS (e.g. localhost:6379).
+func TestRedis(t *testing.T) {
+	address := os.Getenv("BLURR_REDIS_ADDRESS")
+	if address == "" {
+		t.Skip("BLURR_REDIS_ADDRESS is not set")
+	}
+	for _, encoding := range []string{StringEncoding, HashEncoding} {
+		r := &Redis{}
+		r.Init(Config{Addresses: []string{address}, Table: "blurr",
+			Redis: RedisConfig{Encoding: encoding, Pipeline: 4}})
+		doc := map[string]interface{}{"name": "blurr"}
+		if err := r.Create("blurr-test", doc, 60); err != nil {
+			t.Fatal(err)
+		}
+		if value, err := r.Read("blurr-test"); err != nil || !reflect.DeepEqual(value, doc) {
+			t.Errorf("%s: %v != %v (%v)", encoding, value, doc, err)
+		}
+		if err := r.Delete("blurr-test"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Read("blurr-test"); ClassOf(err) != NotFound {
+			t.Errorf("%s: unexpected error: %v", encoding, err)
+		}
+		if err := r.Delete("blurr-test"); ClassOf(err) != NotFound {
+			t.Errorf("%s: unexpected error: %v", encoding, err)
+		}
+		r.Shutdown()
+	}
+}
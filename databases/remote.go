@@ -0,0 +1,416 @@
+package databases
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type RemoteConfig struct {
+	Command []string
+}
+
+// RemoteRequest is a request of the remote driver protocol: newline-delimited
+// JSON messages over the stdin and stdout of the driver process. Responses
+// carry the ID of their request and may arrive out of order. Documents are
+// sent in Value and binary payloads, base64 encoded, in Raw.
+//
+// JSON does not carry Go types: ServeRemote decodes integers of Value and Args
+// as int64, other numbers as float64 and arrays as []interface{}, drivers get
+// e.g. []interface{}{int64(3)} for []int16{3} of the workload.
+type RemoteRequest struct {
+	ID     uint64                 `json:"id"`
+	Op     string                 `json:"op"`
+	Key    string                 `json:"key,omitempty"`
+	Value  map[string]interface{} `json:"value,omitempty"`
+	Raw    []byte                 `json:"raw,omitempty"`
+	Expiry int                    `json:"expiry,omitempty"`
+	Args   []interface{}          `json:"args,omitempty"`
+	Scan   *ScanOptions           `json:"scan,omitempty"`
+	Config *Config                `json:"config,omitempty"`
+}
+
+// RemoteResponse is the response to a RemoteRequest. Durability is returned
+// by init, Summary by shutdown.
+type RemoteResponse struct {
+	ID         uint64                   `json:"id"`
+	Value      map[string]interface{}   `json:"value,omitempty"`
+	Raw        []byte                   `json:"raw,omitempty"`
+	Rows       []map[string]interface{} `json:"rows,omitempty"`
+	Count      int                      `json:"count,omitempty"`
+	Durability string                   `json:"durability,omitempty"`
+	Summary    string                   `json:"summary,omitempty"`
+	Error      *RemoteError             `json:"error,omitempty"`
+}
+
+type RemoteError struct {
+	Class   ErrorClass `json:"class"`
+	Message string     `json:"message"`
+}
+
+// Operations of the remote driver protocol.
+const (
+	RemoteInit     = "init"
+	RemoteShutdown = "shutdown"
+	RemoteCreate   = "create"
+	RemoteRead     = "read"
+	RemoteUpdate   = "update"
+	RemoteDelete   = "delete"
+	RemoteQuery    = "query"
+	RemoteScan     = "scan"
+)
+
+// maxRemoteMessage is the size limit of a response line.
+const maxRemoteMessage = 64 << 20
+
+type remoteClient struct {
+	w         io.WriteCloser
+	writeLock sync.Mutex
+	next      uint64
+	timeout   time.Duration
+	pending   map[uint64]chan *RemoteResponse
+	lock      sync.Mutex
+	err       error
+}
+
+func newRemoteClient(r io.Reader, w io.WriteCloser, timeout time.Duration) *remoteClient {
+	c := &remoteClient{w: w, timeout: timeout, pending: map[uint64]chan *RemoteResponse{}}
+	go c.receive(r)
+	return c
+}
+
+// receive dispatches responses to pending calls until the process closes its
+// output, which fails all pending and later calls.
+func (c *remoteClient) receive(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxRemoteMessage)
+	for scanner.Scan() {
+		resp := &RemoteResponse{}
+		if err := json.Unmarshal(scanner.Bytes(), resp); err != nil {
+			log.Printf("Invalid remote driver response: %v", err)
+			continue
+		}
+		c.lock.Lock()
+		ch, ok := c.pending[resp.ID]
+		delete(c.pending, resp.ID)
+		c.lock.Unlock()
+		if ok {
+			ch <- resp
+		}
+	}
+	err := scanner.Err()
+	if err == nil {
+		err = io.EOF
+	}
+	c.lock.Lock()
+	c.err = &Error{Connection, fmt.Errorf("remote driver: %v", err)}
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	c.lock.Unlock()
+}
+
+func (c *remoteClient) call(req RemoteRequest) (*RemoteResponse, error) {
+	req.ID = atomic.AddUint64(&c.next, 1)
+	ch := make(chan *RemoteResponse, 1)
+	c.lock.Lock()
+	if c.err != nil {
+		c.lock.Unlock()
+		return nil, c.err
+	}
+	c.pending[req.ID] = ch
+	c.lock.Unlock()
+
+	line, err := json.Marshal(req)
+	if err != nil {
+		c.forget(req.ID)
+		return nil, classify(ServerError, err)
+	}
+	c.writeLock.Lock()
+	_, err = c.w.Write(append(line, '\n'))
+	c.writeLock.Unlock()
+	if err != nil {
+		c.forget(req.ID)
+		return nil, classify(Connection, err)
+	}
+
+	var timeout <-chan time.Time
+	if c.timeout > 0 {
+		timer := time.NewTimer(c.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			c.lock.Lock()
+			defer c.lock.Unlock()
+			return nil, c.err
+		}
+		if resp.Error != nil {
+			return resp, remoteError(resp.Error)
+		}
+		return resp, nil
+	case <-timeout:
+		c.forget(req.ID)
+		return nil, &Error{Timeout, fmt.Errorf("remote driver: no response to %s", req.Op)}
+	}
+}
+
+func (c *remoteClient) forget(id uint64) {
+	c.lock.Lock()
+	delete(c.pending, id)
+	c.lock.Unlock()
+}
+
+func remoteError(e *RemoteError) error {
+	class := e.Class
+	if class == "" {
+		class = ServerError
+	}
+	return &Error{class, errors.New(e.Message)}
+}
+
+// Remote forwards operations to an external driver process started with
+// Remote.Command, see RemoteRequest and ServeRemote.
+type Remote struct {
+	cmd        *exec.Cmd
+	client     *remoteClient
+	durability string
+	summary    string
+}
+
+func (r *Remote) Init(config Config) {
+	if len(config.Remote.Command) == 0 {
+		log.Fatal("Remote driver requires Remote.Command")
+	}
+	r.cmd = exec.Command(config.Remote.Command[0], config.Remote.Command[1:]...)
+	r.cmd.Stderr = os.Stderr
+	stdin, err := r.cmd.StdinPipe()
+	if err != nil {
+		log.Fatal(err)
+	}
+	stdout, err := r.cmd.StdoutPipe()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := r.cmd.Start(); err != nil {
+		log.Fatal(err)
+	}
+	r.client = newRemoteClient(stdout, stdin, config.Client.opTimeout(0))
+	r.init(config)
+}
+
+func (r *Remote) init(config Config) {
+	resp, err := r.client.call(RemoteRequest{Op: RemoteInit, Config: &config})
+	if err != nil {
+		log.Fatal(err)
+	}
+	r.durability = resp.Durability
+}
+
+func (r *Remote) Shutdown() {
+	if resp, err := r.client.call(RemoteRequest{Op: RemoteShutdown}); err != nil {
+		log.Print(err)
+	} else {
+		r.summary = resp.Summary
+	}
+	r.client.w.Close()
+	if r.cmd != nil {
+		if err := r.cmd.Wait(); err != nil {
+			log.Printf("Remote driver: %v", err)
+		}
+	}
+}
+
+func (r *Remote) Durability() string {
+	return r.durability
+}
+
+// ReportSummary prints the summary returned by the driver process.
+func (r *Remote) ReportSummary() {
+	if r.summary != "" {
+		fmt.Println(r.summary)
+	}
+}
+
+func remoteValue(req RemoteRequest, value interface{}) RemoteRequest {
+	if raw, ok := value.([]byte); ok {
+		req.Raw = raw
+	} else {
+		req.Value, _ = value.(map[string]interface{})
+	}
+	return req
+}
+
+func (r *Remote) Create(key string, value interface{}, expiry int) error {
+	_, err := r.client.call(remoteValue(RemoteRequest{Op: RemoteCreate, Key: key, Expiry: expiry}, value))
+	return err
+}
+
+func (r *Remote) Read(key string) (interface{}, error) {
+	resp, err := r.client.call(RemoteRequest{Op: RemoteRead, Key: key})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Value != nil {
+		return resp.Value, nil
+	}
+	return resp.Raw, nil
+}
+
+func (r *Remote) Update(key string, value interface{}, expiry int) error {
+	_, err := r.client.call(remoteValue(RemoteRequest{Op: RemoteUpdate, Key: key, Expiry: expiry}, value))
+	return err
+}
+
+func (r *Remote) Delete(key string) error {
+	_, err := r.client.call(RemoteRequest{Op: RemoteDelete, Key: key})
+	return err
+}
+
+// Query sends the catalog query arguments as is, the driver process resolves
+// them against its own catalog.
+func (r *Remote) Query(key string, args []interface{}) ([]map[string]interface{}, error) {
+	resp, err := r.client.call(RemoteRequest{Op: RemoteQuery, Key: key, Args: args})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Rows, nil
+}
+
+func (r *Remote) Scan(startKey string, options ScanOptions) (int, error) {
+	resp, err := r.client.call(RemoteRequest{Op: RemoteScan, Key: startKey, Scan: &options})
+	if err != nil {
+		return 0, err
+	}
+	return resp.Count, nil
+}
+
+// ServeRemote runs db as a remote driver, serving requests read from r until
+// shutdown or the end of input. Requests are served concurrently.
+func ServeRemote(db Database, r io.Reader, w io.Writer) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxRemoteMessage)
+	encoder := json.NewEncoder(w)
+	writeLock := sync.Mutex{}
+	respond := func(resp *RemoteResponse) {
+		writeLock.Lock()
+		defer writeLock.Unlock()
+		if err := encoder.Encode(resp); err != nil {
+			log.Print(err)
+		}
+	}
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+
+	for scanner.Scan() {
+		req := RemoteRequest{}
+		decoder := json.NewDecoder(bytes.NewReader(scanner.Bytes()))
+		decoder.UseNumber()
+		if err := decoder.Decode(&req); err != nil {
+			return err
+		}
+		remoteNumbers(req.Value)
+		remoteNumbers(req.Args)
+		switch req.Op {
+		case RemoteInit:
+			if req.Config != nil {
+				db.Init(*req.Config)
+			}
+			resp := &RemoteResponse{ID: req.ID}
+			if durable, ok := db.(Durable); ok {
+				resp.Durability = durable.Durability()
+			}
+			respond(resp)
+		case RemoteShutdown:
+			wg.Wait()
+			db.Shutdown()
+			respond(&RemoteResponse{ID: req.ID})
+			return nil
+		default:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				respond(serveRemote(db, req))
+			}()
+		}
+	}
+	return scanner.Err()
+}
+
+// remoteNumbers replaces numbers decoded as json.Number in place.
+func remoteNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for key, element := range v {
+			v[key] = remoteNumbers(element)
+		}
+	case []interface{}:
+		for i, element := range v {
+			v[i] = remoteNumbers(element)
+		}
+	}
+	return value
+}
+
+func serveRemote(db Database, req RemoteRequest) *RemoteResponse {
+	resp := &RemoteResponse{ID: req.ID}
+	var value interface{} = req.Value
+	if req.Value == nil {
+		value = req.Raw
+	}
+	var err error
+	switch req.Op {
+	case RemoteCreate:
+		err = db.Create(req.Key, value, req.Expiry)
+	case RemoteRead:
+		var result interface{}
+		result, err = db.Read(req.Key)
+		switch v := result.(type) {
+		case map[string]interface{}:
+			resp.Value = v
+		case []byte:
+			resp.Raw = v
+		}
+	case RemoteUpdate:
+		err = db.Update(req.Key, value, req.Expiry)
+	case RemoteDelete:
+		err = db.Delete(req.Key)
+	case RemoteQuery:
+		resp.Rows, err = db.Query(req.Key, req.Args)
+	case RemoteScan:
+		scanner, ok := db.(Scanner)
+		if !ok || req.Scan == nil {
+			err = fmt.Errorf("scans are not supported")
+			break
+		}
+		resp.Count, err = scanner.Scan(req.Key, *req.Scan)
+	default:
+		err = fmt.Errorf("unknown operation: %s", req.Op)
+	}
+	if err != nil {
+		message := err.Error()
+		if e, ok := err.(*Error); ok {
+			message = e.Err.Error()
+		}
+		resp.Error = &RemoteError{Class: ClassOf(err), Message: message}
+	}
+	return resp
+}
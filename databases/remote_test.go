@@ -0,0 +1,143 @@
+package databases
+
+import (
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type memDatabase struct {
+	documents map[string]interface{}
+	lock      sync.Mutex
+}
+
+func (db *memDatabase) Init(config Config) {
+	db.documents = map[string]interface{}{}
+}
+
+func (db *memDatabase) Shutdown() {}
+
+func (db *memDatabase) Create(key string, value interface{}, expiry int) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.documents[key] = value
+	return nil
+}
+
+func (db *memDatabase) Read(key string) (interface{}, error) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	value, ok := db.documents[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return value, nil
+}
+
+func (db *memDatabase) Update(key string, value interface{}, expiry int) error {
+	return db.Create(key, value, expiry)
+}
+
+func (db *memDatabase) Delete(key string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	delete(db.documents, key)
+	return nil
+}
+
+func (db *memDatabase) Query(key string, args []interface{}) ([]map[string]interface{}, error) {
+	return []map[string]interface{}{{"args": len(args)}}, nil
+}
+
+func TestRemote(t *testing.T) {
+	requests, requestWriter := io.Pipe()
+	responses, responseWriter := io.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- ServeRemote(&memDatabase{}, requests, responseWriter)
+		responseWriter.Close()
+	}()
+
+	r := &Remote{client: newRemoteClient(responses, requestWriter, 0)}
+	r.init(Config{})
+
+	wg := sync.WaitGroup{}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			if err := r.Create(key, map[string]interface{}{"key": key}, 0); err != nil {
+				t.Error(err)
+			}
+		}(key)
+	}
+	wg.Wait()
+	if err := r.Update("raw", []byte{0, 1, 2}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if value, err := r.Read("c"); err != nil || value.(map[string]interface{})["key"] != "c" {
+		t.Errorf("unexpected document: %v (%v)", value, err)
+	}
+	if value, err := r.Read("raw"); err != nil || len(value.([]byte)) != 3 {
+		t.Errorf("unexpected payload: %v (%v)", value, err)
+	}
+	if _, err := r.Read("e"); ClassOf(err) != NotFound {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if rows, err := r.Query("a", []interface{}{"q", 1}); err != nil || rows[0]["args"] != 2.0 {
+		t.Errorf("unexpected rows: %v (%v)", rows, err)
+	}
+	if _, err := r.Scan("a", ScanOptions{Length: 10}); err == nil {
+		t.Error("scans are not supported by the database")
+	}
+
+	r.Shutdown()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read("a"); ClassOf(err) != Connection {
+		t.Errorf("unexpected error after shutdown: %v", err)
+	}
+}
+
+func TestRemoteNumbers(t *testing.T) {
+	requests, requestWriter := io.Pipe()
+	responses, responseWriter := io.Pipe()
+	db := &memDatabase{}
+	go func() {
+		ServeRemote(db, requests, responseWriter)
+		responseWriter.Close()
+	}()
+
+	r := &Remote{client: newRemoteClient(responses, requestWriter, 0)}
+	r.init(Config{})
+	doc := map[string]interface{}{
+		"coins":        map[string]interface{}{"f": 3.0},
+		"year":         int16(1990),
+		"achievements": []int16{3, 1},
+	}
+	if err := r.Create("a", doc, 0); err != nil {
+		t.Fatal(err)
+	}
+	r.Shutdown()
+
+	names, values := typedColumns(db.documents["a"].(map[string]interface{}), nil, nil)
+	expected := map[string]interface{}{
+		"coins":        3.0,
+		"year":         int64(1990),
+		"achievements": []interface{}{int64(3), int64(1)},
+		"achievement":  int64(3),
+	}
+	columns := map[string]interface{}{}
+	for i, name := range names {
+		columns[name] = values[i]
+	}
+	if !reflect.DeepEqual(columns, expected) {
+		t.Errorf("%v != %v", columns, expected)
+	}
+	if arg := sqlArg(columns["achievements"]); arg != "[3,1]" {
+		t.Errorf("unexpected argument: %v", arg)
+	}
+}
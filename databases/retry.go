@@ -0,0 +1,185 @@
+package databases
+
+import (
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbaselabs/blurr/stats"
+)
+
+type RetryConfig struct {
+	MaxAttempts int
+	Backoff     int
+	MaxBackoff  int
+	Jitter      float64
+	RetryOn     []ErrorClass
+}
+
+var defaultRetryOn = []ErrorClass{Timeout, Temporary, Connection}
+
+type retryStats struct {
+	Attempts   stats.Histogram
+	Latency    stats.Histogram
+	Retried    int64
+	Succeeded  int64
+	Failed     int64
+	GaveUp     int64
+	NotRetried int64
+}
+
+// Retry wraps a database and retries operations that fail with retryable
+// error classes using exponential backoff with jitter.
+type Retry struct {
+	Database
+	Config RetryConfig
+	stats  map[string]*retryStats
+}
+
+var retryOps = []string{"Create", "Read", "Update", "Delete", "Query", "Scan"}
+
+func (r *Retry) Init(config Config) {
+	r.Config = config.Retry
+	if r.Config.Backoff <= 0 {
+		r.Config.Backoff = 10
+	}
+	if r.Config.MaxBackoff <= 0 {
+		r.Config.MaxBackoff = 1000
+	}
+	if len(r.Config.RetryOn) == 0 {
+		r.Config.RetryOn = defaultRetryOn
+	}
+	r.stats = map[string]*retryStats{}
+	for _, op := range retryOps {
+		r.stats[op] = &retryStats{}
+	}
+	r.Database.Init(config)
+}
+
+func (r *Retry) retryable(err error) bool {
+	class := ClassOf(err)
+	for _, retryable := range r.Config.RetryOn {
+		if class == retryable {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff doubles the initial backoff with every attempt until it reaches
+// MaxBackoff.
+func (r *Retry) backoff(attempt int) time.Duration {
+	backoff := float64(r.Config.Backoff)
+	for i := 1; i < attempt && backoff < float64(r.Config.MaxBackoff); i++ {
+		backoff *= 2
+	}
+	if backoff > float64(r.Config.MaxBackoff) {
+		backoff = float64(r.Config.MaxBackoff)
+	}
+	backoff -= backoff * r.Config.Jitter * rand.Float64()
+	return time.Duration(backoff * float64(time.Millisecond))
+}
+
+func (r *Retry) do(op string, f func() error) error {
+	s := r.stats[op]
+
+	err := f()
+	attempts := 1
+	t0 := time.Now()
+	for err != nil && attempts < r.Config.MaxAttempts && r.retryable(err) {
+		time.Sleep(r.backoff(attempts))
+		err = f()
+		attempts++
+	}
+
+	s.Attempts.Record(float64(attempts))
+	if attempts > 1 {
+		atomic.AddInt64(&s.Retried, 1)
+		s.Latency.Record(float64(time.Since(t0)/time.Microsecond) / 1000)
+	}
+	switch {
+	case err == nil:
+		atomic.AddInt64(&s.Succeeded, 1)
+	case r.retryable(err):
+		atomic.AddInt64(&s.Failed, 1)
+		atomic.AddInt64(&s.GaveUp, 1)
+	default:
+		atomic.AddInt64(&s.Failed, 1)
+		atomic.AddInt64(&s.NotRetried, 1)
+	}
+	return err
+}
+
+func (r *Retry) Create(key string, value interface{}, expiry int) error {
+	return r.do("Create", func() error { return r.Database.Create(key, value, expiry) })
+}
+
+func (r *Retry) Read(key string) (value interface{}, err error) {
+	err = r.do("Read", func() error {
+		value, err = r.Database.Read(key)
+		return err
+	})
+	return value, err
+}
+
+func (r *Retry) Update(key string, value interface{}, expiry int) error {
+	return r.do("Update", func() error { return r.Database.Update(key, value, expiry) })
+}
+
+func (r *Retry) Delete(key string) error {
+	return r.do("Delete", func() error { return r.Database.Delete(key) })
+}
+
+func (r *Retry) Query(key string, args []interface{}) (rows []map[string]interface{}, err error) {
+	err = r.do("Query", func() error {
+		rows, err = r.Database.Query(key, args)
+		return err
+	})
+	return rows, err
+}
+
+func (r *Retry) Scan(startKey string, options ScanOptions) (scanned int, err error) {
+	scanner, ok := r.Database.(Scanner)
+	if !ok {
+		return 0, &Error{ServerError, fmt.Errorf("scans are not supported")}
+	}
+	err = r.do("Scan", func() error {
+		scanned, err = scanner.Scan(startKey, options)
+		return err
+	})
+	return scanned, err
+}
+
+func (r *Retry) Durability() string {
+	if durable, ok := r.Database.(Durable); ok {
+		return durable.Durability()
+	}
+	return ""
+}
+
+func (r *Retry) ReportSummary() {
+	fmt.Println("Retries:")
+	for _, op := range retryOps {
+		s := r.stats[op]
+		total := s.Attempts.Total()
+		if total == 0 {
+			continue
+		}
+		fmt.Printf("\t%v:\n", op)
+		fmt.Printf("\t\tAttempts per op: %.3f (max: %.0f)\n", s.Attempts.Mean(), s.Attempts.Max)
+		fmt.Printf("\t\tRetried ops: %v\n", s.Retried)
+		if s.Retried > 0 {
+			fmt.Printf("\t\tRetry latency: mean %.2f ms, 99th percentile %.2f ms\n",
+				s.Latency.Mean(), s.Latency.Percentile(0.99))
+		}
+		fmt.Printf("\t\tSucceeded: %v (%.2f%%)\n", s.Succeeded,
+			100*float64(s.Succeeded)/float64(total))
+		fmt.Printf("\t\tFailed: %v (gave up: %v, not retryable: %v)\n",
+			s.Failed, s.GaveUp, s.NotRetried)
+	}
+
+	if reporter, ok := r.Database.(Reporter); ok {
+		reporter.ReportSummary()
+	}
+}
@@ -0,0 +1,83 @@
+package databases
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type flakyDatabase struct {
+	failures int
+	err      error
+	calls    int
+}
+
+func (db *flakyDatabase) Init(config Config) {}
+
+func (db *flakyDatabase) Shutdown() {}
+
+func (db *flakyDatabase) fail() error {
+	db.calls++
+	if db.calls <= db.failures {
+		return db.err
+	}
+	return nil
+}
+
+func (db *flakyDatabase) Create(key string, value interface{}, expiry int) error {
+	return db.fail()
+}
+
+func (db *flakyDatabase) Read(key string) (interface{}, error) {
+	return nil, db.fail()
+}
+
+func (db *flakyDatabase) Update(key string, value interface{}, expiry int) error {
+	return db.fail()
+}
+
+func (db *flakyDatabase) Delete(key string) error {
+	return db.fail()
+}
+
+func (db *flakyDatabase) Query(key string, args []interface{}) ([]map[string]interface{}, error) {
+	return nil, db.fail()
+}
+
+func TestRetry(t *testing.T) {
+	config := Config{Retry: RetryConfig{MaxAttempts: 3, Backoff: 1}}
+
+	db := &flakyDatabase{failures: 2, err: &Error{Temporary, errors.New("tmpfail")}}
+	retry := &Retry{Database: db}
+	retry.Init(config)
+	if _, err := retry.Read("key"); err != nil || db.calls != 3 {
+		t.Errorf("temporary failures: err=%v, calls=%v", err, db.calls)
+	}
+
+	db = &flakyDatabase{failures: 5, err: &Error{Timeout, errors.New("timeout")}}
+	retry = &Retry{Database: db}
+	retry.Init(config)
+	if _, err := retry.Read("key"); ClassOf(err) != Timeout || db.calls != 3 {
+		t.Errorf("persistent failures: err=%v, calls=%v", err, db.calls)
+	}
+
+	db = &flakyDatabase{failures: 1, err: ErrNotFound}
+	retry = &Retry{Database: db}
+	retry.Init(config)
+	if _, err := retry.Read("key"); err != ErrNotFound || db.calls != 1 {
+		t.Errorf("non-retryable failure: err=%v, calls=%v", err, db.calls)
+	}
+	if retry.stats["Read"].NotRetried != 1 {
+		t.Errorf("non-retryable failure is not accounted")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	retry := &Retry{Database: &flakyDatabase{}}
+	retry.Init(Config{Retry: RetryConfig{Backoff: 10, MaxBackoff: 1000}})
+	for attempt, expected := range map[int]time.Duration{1: 10, 2: 20, 7: 640, 8: 1000, 64: 1000, 1000: 1000} {
+		if backoff := retry.backoff(attempt); backoff != expected*time.Millisecond {
+			t.Errorf("attempt %d: %v", attempt, backoff)
+		}
+	}
+}
@@ -2,37 +2,229 @@ package databases
 
 import (
 	"bytes"
-	"errors"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"math/rand"
 	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbaselabs/blurr/stats"
 )
 
 type RestClient struct {
-	client *http.Client
-	URIs   []string
+	client          *http.Client
+	URIs            []string
+	Balancing       string
+	ScanConsistency string
+	conns           *connStats
+	endpoints       []*endpointStats
+	next            uint64
+	username        string
+	password        string
+}
+
+type TuqConfig struct {
+	Balancing string
+}
+
+// Balancing policies of query requests across URIs.
+const (
+	RandomBalancing           = "Random"
+	RoundRobinBalancing       = "RoundRobin"
+	LeastOutstandingBalancing = "LeastOutstanding"
+)
+
+// endpointStats are statistics of query requests sent to a URI. Status code 0
+// stands for requests without a response.
+type endpointStats struct {
+	Requests    int64
+	Errors      int64
+	Outstanding int64
+	Latency     stats.Histogram
+	statuses    map[int]int64
+	lock        sync.Mutex
+}
+
+func newRestClient(client *http.Client, URIs []string, balancing string) *RestClient {
+	switch balancing {
+	case "":
+		balancing = RandomBalancing
+	case RandomBalancing, RoundRobinBalancing, LeastOutstandingBalancing:
+	default:
+		log.Fatalf("Unknown balancing policy: %s", balancing)
+	}
+	c := &RestClient{client: client, URIs: URIs, Balancing: balancing}
+	for range URIs {
+		c.endpoints = append(c.endpoints, &endpointStats{statuses: map[int]int64{}})
+	}
+	return c
 }
 
-func (c RestClient) Do(q string) error {
-	data := bytes.NewReader([]byte(q))
-	uri := c.URIs[rand.Intn(len(c.URIs))]
-	req, err := http.NewRequest("POST", uri, data)
-	req.Header.Set("Content-Type", "text/plain")
+// pick returns the index of the URI for the next request.
+func (c *RestClient) pick() int {
+	switch c.Balancing {
+	case RoundRobinBalancing:
+		return int((atomic.AddUint64(&c.next, 1) - 1) % uint64(len(c.URIs)))
+	case LeastOutstandingBalancing:
+		offset := rand.Intn(len(c.URIs))
+		best := offset
+		for i := range c.URIs {
+			j := (offset + i) % len(c.URIs)
+			if atomic.LoadInt64(&c.endpoints[j].Outstanding) <
+				atomic.LoadInt64(&c.endpoints[best].Outstanding) {
+				best = j
+			}
+		}
+		return best
+	}
+	return rand.Intn(len(c.URIs))
+}
+
+func (e *endpointStats) record(status int, err error, latency float64) {
+	atomic.AddInt64(&e.Requests, 1)
+	if err != nil {
+		atomic.AddInt64(&e.Errors, 1)
+	}
+	e.Latency.Record(latency)
+	e.lock.Lock()
+	e.statuses[status]++
+	e.lock.Unlock()
+}
+
+func (c *RestClient) ReportSummary() {
+	c.reportEndpoints("Query endpoints")
+}
+
+func (c *RestClient) reportEndpoints(title string) {
+	fmt.Printf("%s (%s):\n", title, c.Balancing)
+	for i, uri := range c.URIs {
+		e := c.endpoints[i]
+		if e.Requests == 0 {
+			fmt.Printf("\t%s: no requests\n", uri)
+			continue
+		}
+		fmt.Printf("\t%s:\n", uri)
+		fmt.Printf("\t\tRequests: %v (errors: %v)\n", e.Requests, e.Errors)
+		fmt.Printf("\t\tLatency: mean %.2f ms, 99th percentile %.2f ms\n",
+			e.Latency.Mean(), e.Latency.Percentile(0.99))
+		codes := make([]int, 0, len(e.statuses))
+		for code := range e.statuses {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			fmt.Printf("\t\tStatus %d: %v\n", code, e.statuses[code])
+		}
+	}
+}
+
+func statusError(code int) error {
+	err := fmt.Errorf("bad status code: %d", code)
+	switch {
+	case code == http.StatusNotFound:
+		return &Error{NotFound, err}
+	case code == http.StatusConflict:
+		return &Error{CASConflict, err}
+	case code == http.StatusRequestTimeout || code == http.StatusGatewayTimeout:
+		return &Error{Timeout, err}
+	case code == http.StatusTooManyRequests || code == http.StatusServiceUnavailable:
+		return &Error{Temporary, err}
+	}
+	return &Error{ServerError, err}
+}
+
+// n1qlError adds the messages of a N1QL error response to the status error.
+func n1qlError(code int, body []byte) error {
+	err := statusError(code).(*Error)
+	response := struct {
+		Errors []struct {
+			Code int    `json:"code"`
+			Msg  string `json:"msg"`
+		} `json:"errors"`
+	}{}
+	if json.Unmarshal(body, &response) == nil {
+		for _, e := range response.Errors {
+			err.Err = fmt.Errorf("%v; %d: %s", err.Err, e.Code, e.Msg)
+		}
+	}
+	return err
+}
+
+// Do sends the statement to the URI chosen by the balancing policy and
+// records the request in the stats of the endpoint.
+func (c *RestClient) Do(q string) ([]byte, error) {
+	return c.send(func(uri string) ([]byte, int, error) {
+		return c.post(uri, q)
+	})
+}
+
+// send calls request with the URI chosen by the balancing policy.
+func (c *RestClient) send(request func(uri string) ([]byte, int, error)) ([]byte, error) {
+	i := c.pick()
+	e := c.endpoints[i]
+	atomic.AddInt64(&e.Outstanding, 1)
+	t0 := time.Now()
+	body, status, err := request(c.URIs[i])
+	e.record(status, err, float64(time.Since(t0)/time.Microsecond)/1000)
+	atomic.AddInt64(&e.Outstanding, -1)
+	return body, err
+}
+
+// post sends the statement as plain text, or as a form with scan_consistency
+// when it is configured.
+func (c *RestClient) post(uri, q string) ([]byte, int, error) {
+	data, contentType := []byte(q), "text/plain"
+	if c.ScanConsistency != "" {
+		form := url.Values{"statement": {q}, "scan_consistency": {c.ScanConsistency}}
+		data, contentType = []byte(form.Encode()), "application/x-www-form-urlencoded"
+	}
+	req, err := http.NewRequest("POST", uri, bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, classify(ServerError, err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
 
 	resp, err := c.client.Do(req)
 	if err != nil {
-		return err
+		return nil, 0, classify(Connection, err)
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return errors.New("Bad status code")
+		body, _ := ioutil.ReadAll(resp.Body)
+		return nil, resp.StatusCode, n1qlError(resp.StatusCode, body)
 	}
 
-	defer resp.Body.Close()
-	_, err = ioutil.ReadAll(resp.Body)
+	body, err := ioutil.ReadAll(resp.Body)
 
-	return err
+	return body, resp.StatusCode, classify(Connection, err)
+}
+
+// queryResponse covers both the developer preview ("resultset") and the
+// current ("results") N1QL response formats.
+type queryResponse struct {
+	Resultset []map[string]interface{} `json:"resultset"`
+	Results   []map[string]interface{} `json:"results"`
+}
+
+func decodeRows(body []byte) ([]map[string]interface{}, error) {
+	response := queryResponse{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, &Error{ServerError, err}
+	}
+	if response.Results != nil {
+		return response.Results, nil
+	}
+	return response.Resultset, nil
 }
 
 type Tuq struct {
@@ -49,167 +241,176 @@ func (t *Tuq) Init(config Config) {
 		URIs = append(URIs, fmt.Sprintf("%squery", address))
 	}
 
-	tr := &http.Transport{MaxIdleConnsPerHost: MaxIdleConnsPerHost}
-	t.client = &RestClient{&http.Client{Transport: tr}, URIs}
+	switch config.Durability.Read {
+	case "", "not_bounded", "request_plus":
+	default:
+		log.Fatalf("Unknown scan consistency: %s", config.Durability.Read)
+	}
+
+	if config.TLS.Enabled {
+		log.Fatal("Tuq driver does not support TLS")
+	}
+
+	config.Client.sessionReuse("Tuq", PooledSessions, SharedSessions, NoSessionReuse)
+	conns := newConnStats(config.Client)
+	client := &http.Client{
+		Transport: conns.transport(config.Client, nil, MaxIdleConnsPerHost),
+		Timeout:   config.Client.opTimeout(0),
+	}
+	t.client = newRestClient(client, URIs, config.Tuq.Balancing)
+	t.client.ScanConsistency = config.Durability.Read
+	t.client.conns = conns
+	t.client.username, t.client.password = config.Username, config.Password
 	t.bucket = config.Table
 
+	// Durability.Read applies to queries, not to views of the KV connection.
+	config.Durability.Read = ""
 	t.cb = Couchbase{}
 	t.cb.Init(config)
 }
 
+func (t *Tuq) Durability() string {
+	scanConsistency := t.client.ScanConsistency
+	if scanConsistency == "" {
+		scanConsistency = "not_bounded"
+	}
+	return fmt.Sprintf("%s; queries: scan_consistency=%s", t.cb.writeDurability(), scanConsistency)
+}
+
 func (t *Tuq) Shutdown() {}
 
-func (t *Tuq) Create(key string, value map[string]interface{}) error {
-	return t.cb.Create(key, value)
+func (t *Tuq) ReportSummary() {
+	t.client.conns.ReportSummary()
+	t.client.ReportSummary()
+	t.cb.reportNodes()
+}
+
+func (t *Tuq) Create(key string, value interface{}, expiry int) error {
+	return t.cb.Create(key, value, expiry)
 }
 
-func (t *Tuq) Read(key string) error {
+func (t *Tuq) Read(key string) (interface{}, error) {
 	return t.cb.Read(key)
 }
 
-func (t *Tuq) Update(key string, value map[string]interface{}) error {
-	return t.cb.Update(key, value)
+func (t *Tuq) Update(key string, value interface{}, expiry int) error {
+	return t.cb.Update(key, value, expiry)
 }
 
 func (t *Tuq) Delete(key string) error {
 	return t.cb.Delete(key)
 }
 
-func (t *Tuq) Query(key string, args []interface{}) error {
-	index := args[0].(string)
-
-	var q string
-	switch index {
-	case "name_and_street_by_city":
-		query := `
-			SELECT name.f.f.f AS _name, street.f.f AS _street
-				FROM %s
-				WHERE city.f.f = "%s"
-				LIMIT 20`
-		q = fmt.Sprintf(query, t.bucket, args[1])
-	case "name_and_email_by_county":
-		query := `
-			SELECT name.f.f.f AS _name, email.f.f AS _email
-				FROM %s
-				WHERE county.f.f = "%s"
-				LIMIT 20`
-		q = fmt.Sprintf(query, t.bucket, args[1])
-	case "achievements_by_realm":
-		query := `
-			SELECT achievements
-				FROM %s
-				WHERE realm.f = "%s"
-				LIMIT 20`
-		q = fmt.Sprintf(query, t.bucket, args[1])
-	case "name_by_coins":
-		query := `
-			SELECT name.f.f.f AS _name
-				FROM %s
-				WHERE coins.f > %f AND coins.f < %f
-				LIMIT 20`
-		q = fmt.Sprintf(query, t.bucket, args[1].(float64)*0.5, args[1])
-	case "email_by_achievement_and_category":
-		query := `
-			SELECT email.f.f AS _email
-				FROM %s
-				WHERE category = %d AND achievements[0] > 0 AND achievements[0] < %d
-				LIMIT 20`
-		q = fmt.Sprintf(query, t.bucket, args[2].(int16), args[1].([]int16)[0])
-	case "street_by_year_and_coins":
-		query := `
-			SELECT street.f.f AS _street
-				FROM %s
-				WHERE year = %d AND coins.f > %f AND coins.f < 655.35
-				LIMIT 20`
-		q = fmt.Sprintf(query, t.bucket, args[1], args[2].(float64))
-	case "name_and_email_and_street_and_achievements_and_coins_by_city":
-		query := `
-			SELECT name.f.f.f AS _name, email.f.f AS _email, street.f.f AS _street, achievements, coins.f AS _coins
-				FROM %s
-				WHERE city.f.f = "%s"
-				LIMIT 20`
-		q = fmt.Sprintf(query, t.bucket, args[1])
-	case "street_and_name_and_email_and_achievement_and_coins_by_county":
-		query := `
-			SELECT street.f.f AS _street, name.f.f.f AS _name, email.f.f AS _email, achievements[0] AS achievement, 2*coins.f AS _coins
-				FROM %s
-				WHERE county.f.f = "%s"
-				LIMIT 20`
-		q = fmt.Sprintf(query, t.bucket, args[1])
-	case "category_name_and_email_and_street_and_gmtime_and_year_by_country":
-		query := `
-			SELECT category, name.f.f.f AS _name, email.f.f AS _email, street.f.f AS _street, gmtime, year
-				FROM %s
-				WHERE country.f = "%s"
-				LIMIT 20`
-		q = fmt.Sprintf(query, t.bucket, args[1])
-	case "body_by_city":
-		query := `
-			SELECT body
-				FROM %s
-				WHERE city.f.f = "%s"
-				LIMIT 20`
-		q = fmt.Sprintf(query, t.bucket, args[1])
-	case "body_by_realm":
-		query := `
-			SELECT body
-				FROM %s
-				WHERE realm.f = "%s"
-				LIMIT 20`
-		q = fmt.Sprintf(query, t.bucket, args[1])
-	case "body_by_country":
-		query := `
-			SELECT body
-				FROM %s
-				WHERE country.f = "%s"
-				LIMIT 20`
-		q = fmt.Sprintf(query, t.bucket, args[1])
-	case "distinct_states":
-		query := `
-			SELECT DISTINCT state.f AS state
-				FROM %s
-				LIMIT 20`
-		q = fmt.Sprintf(query, t.bucket)
-	case "distinct_full_states":
-		query := `
-			SELECT DISTINCT full_state.f AS full_state
-				FROM %s
-				LIMIT 20`
-		q = fmt.Sprintf(query, t.bucket)
-	case "distinct_years":
-		query := `
-			SELECT DISTINCT year
-				FROM %s
-				LIMIT 20`
-		q = fmt.Sprintf(query, t.bucket)
-	case "coins_stats_by_state_and_year":
-		query := `
-			SELECT COUNT(coins.f), SUM(coins.f), AVG(coins.f), MIN(coins.f), MAX(coins.f)
-				FROM %s
-				WHERE state.f = "%s" AND year = %d
-				GROUP BY state.f, year
-				LIMIT 20`
-		q = fmt.Sprintf(query, t.bucket, args[1], args[2])
-	case "coins_stats_by_gmtime_and_year":
-		query := `
-			SELECT COUNT(coins.f), SUM(coins.f), AVG(coins.f), MIN(coins.f), MAX(coins.f)
-				FROM %s
-				WHERE gmtime = [%d, %d, %d, %d, %d, %d, %d, %d, %d] AND year = %d
-				GROUP BY gmtime, year
-				LIMIT 20`
-		gmtime := args[1].([]int16)
-		q = fmt.Sprintf(query, t.bucket,
-			gmtime[0], gmtime[1], gmtime[2], gmtime[3], gmtime[4], gmtime[5], gmtime[6], gmtime[7], gmtime[8],
-			args[2])
-	case "coins_stats_by_full_state_and_year":
-		query := `
-			SELECT COUNT(coins.f), SUM(coins.f), AVG(coins.f), MIN(coins.f), MAX(coins.f)
-				FROM %s
-				WHERE full_state.f = "%s" AND year = %d
-				GROUP BY full_state.f, year
-				LIMIT 20`
-		q = fmt.Sprintf(query, t.bucket, args[1], args[2])
-	}
-
-	return t.client.Do(q)
+func (t *Tuq) Query(key string, args []interface{}) ([]map[string]interface{}, error) {
+	q, values, err := t.cb.Catalog.Prepare(args)
+	if err != nil {
+		return nil, err
+	}
+	if q.N1QL == nil {
+		return nil, &Error{ServerError, fmt.Errorf("no statement for query %s", q.Name)}
+	}
+	values["bucket"] = t.bucket
+	statement, err := q.Statement(values)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := t.client.Do(statement)
+	if err != nil {
+		return nil, err
+	}
+	return decodeRows(body)
+}
+
+func (t *Tuq) gsiIndexes(indexes []string) ([]GSIIndex, error) {
+	if err := t.cb.Catalog.Check("Tuq", indexes); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	result := []GSIIndex{}
+	for _, index := range indexes {
+		q, _ := t.cb.Catalog.Get(index)
+		if gsi := q.N1QL.Index; gsi.Name != "" && !seen[gsi.Name] {
+			seen[gsi.Name] = true
+			result = append(result, gsi)
+		}
+	}
+	return result, nil
+}
+
+// Setup creates GSI indexes for the given query indexes, existing indexes are
+// kept.
+func (t *Tuq) Setup(indexes []string) error {
+	gsis, err := t.gsiIndexes(indexes)
+	if err != nil {
+		return err
+	}
+	for _, gsi := range gsis {
+		var q string
+		if gsi.Keys == "" {
+			q = fmt.Sprintf("CREATE PRIMARY INDEX ON %s USING GSI", t.bucket)
+		} else {
+			q = fmt.Sprintf("CREATE INDEX %s ON %s(%s) USING GSI", gsi.Name, t.bucket, gsi.Keys)
+		}
+		if _, err := t.client.Do(q); ignoreSchemaError(err, "already exists") != nil {
+			return fmt.Errorf("%s: %v", q, err)
+		}
+	}
+	return nil
+}
+
+// Teardown drops GSI indexes created by Setup, missing indexes are skipped.
+func (t *Tuq) Teardown(indexes []string) error {
+	gsis, err := t.gsiIndexes(indexes)
+	if err != nil {
+		return err
+	}
+	for _, gsi := range gsis {
+		var q string
+		if gsi.Keys == "" {
+			q = fmt.Sprintf("DROP PRIMARY INDEX ON %s USING GSI", t.bucket)
+		} else {
+			q = fmt.Sprintf("DROP INDEX %s.%s USING GSI", t.bucket, gsi.Name)
+		}
+		if _, err := t.client.Do(q); ignoreSchemaError(err, "not found", "does not exist") != nil {
+			return fmt.Errorf("%s: %v", q, err)
+		}
+	}
+	return nil
+}
+
+// Scan reads documents in key order. Results of a statement are streamed, so
+// cursor pagination reads all documents with a single statement.
+func (t *Tuq) Scan(startKey string, options ScanOptions) (int, error) {
+	start, _ := json.Marshal(startKey)
+	condition := fmt.Sprintf("META().id >= %s", start)
+	if options.Pagination == CursorPagination {
+		options.PageSize = options.Length
+	}
+	scanned := 0
+	for scanned < options.Length {
+		limit := options.pageLimit(scanned)
+		q := fmt.Sprintf("SELECT META().id AS id, * FROM %s WHERE %s ORDER BY META().id LIMIT %d",
+			t.bucket, condition, limit)
+		if options.Pagination == OffsetPagination || options.Pagination == "" {
+			q += fmt.Sprintf(" OFFSET %d", scanned)
+		}
+		body, err := t.client.Do(q)
+		if err != nil {
+			return scanned, err
+		}
+		rows, err := decodeRows(body)
+		if err != nil {
+			return scanned, err
+		}
+		scanned += len(rows)
+		if len(rows) < limit {
+			break
+		}
+		if options.Pagination == KeysetPagination {
+			last, _ := json.Marshal(rows[len(rows)-1]["id"])
+			condition = fmt.Sprintf("META().id > %s", last)
+		}
+	}
+	return scanned, nil
 }
@@ -0,0 +1,47 @@
+package databases
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRestClientBalancing(t *testing.T) {
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"results": []}`))
+	}))
+	defer ok.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	client := newRestClient(http.DefaultClient, []string{ok.URL, failing.URL}, RoundRobinBalancing)
+	for i := 0; i < 4; i++ {
+		client.Do("SELECT 1")
+	}
+
+	for i, e := range client.endpoints {
+		if e.Requests != 2 || e.Outstanding != 0 || e.Latency.Total() != 2 {
+			t.Errorf("%s: unexpected stats: %+v", client.URIs[i], e)
+		}
+	}
+	if client.endpoints[0].statuses[200] != 2 || client.endpoints[0].Errors != 0 {
+		t.Errorf("unexpected statuses: %v", client.endpoints[0].statuses)
+	}
+	if client.endpoints[1].statuses[503] != 2 || client.endpoints[1].Errors != 2 {
+		t.Errorf("unexpected statuses: %v", client.endpoints[1].statuses)
+	}
+}
+
+func TestN1QLError(t *testing.T) {
+	body := []byte(`{"errors": [{"code": 4300, "msg": "The index #primary already exists."}], "status": "errors"}`)
+	err := n1qlError(http.StatusInternalServerError, body)
+	if ClassOf(err) != ServerError || ignoreSchemaError(err, "already exists") != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	err = n1qlError(http.StatusNotFound, []byte("not json"))
+	if ClassOf(err) != NotFound || ignoreSchemaError(err, "already exists") == nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/thomas-couchbase/blurr/workloads"
+)
+
+// ClusterConfig lists the agents (host:port) of a distributed run.
+type ClusterConfig struct {
+	Agents []string
+}
+
+// partition splits operations and throughput of the workload across agents,
+// which interleave their keys by client index. Remainders go to the first
+// agents. Throughput lower than the number of agents cannot be split, agents
+// without throughput would run unthrottled.
+func partition(config Config, agents int) ([]Config, error) {
+	if t := config.Workload.Throughput; t > 0 && t < agents {
+		return nil, fmt.Errorf("'Throughput' is lower than the number of agents")
+	}
+	if t := config.Workload.QueryThroughput; t > 0 && t < agents {
+		return nil, fmt.Errorf("'QueryThroughput' is lower than the number of agents")
+	}
+	share := func(value int64, i int) int64 {
+		if int64(i) < value%int64(agents) {
+			return value/int64(agents) + 1
+		}
+		return value / int64(agents)
+	}
+	configs := make([]Config, agents)
+	for i := range configs {
+		c := config
+		c.Workload.ClientIndex, c.Workload.ClientCount = i, agents
+		c.Workload.Operations = share(config.Workload.Operations, i)
+		c.Workload.Throughput = int(share(int64(config.Workload.Throughput), i))
+		c.Workload.QueryThroughput = int(share(int64(config.Workload.QueryThroughput), i))
+		configs[i] = c
+	}
+	return configs, nil
+}
+
+func callAgent(agent, path string, request, response interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	resp, err := http.Post("http://"+agent+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(message))
+	}
+	if response == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
+
+// eachAgent calls fn for all agents in parallel and fails on the first error.
+func eachAgent(agents []string, fn func(i int, agent string) error) {
+	wg := sync.WaitGroup{}
+	errs := make([]error, len(agents))
+	for i, agent := range agents {
+		wg.Add(1)
+		go func(i int, agent string) {
+			defer wg.Done()
+			errs[i] = fn(i, agent)
+		}(i, agent)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			log.Fatalf("Agent %s: %v", agents[i], err)
+		}
+	}
+}
+
+// coordinate prepares all agents, starts them together once all of them are
+// ready and merges their results.
+func coordinate(config Config) *workloads.State {
+	agents := config.Cluster.Agents
+	configs, err := partition(config, len(agents))
+	if err != nil {
+		log.Fatal(err)
+	}
+	eachAgent(agents, func(i int, agent string) error {
+		return callAgent(agent, "/prepare", configs[i], nil)
+	})
+
+	log.Printf("Starting %d agents", len(agents))
+	states := make([]workloads.State, len(agents))
+	eachAgent(agents, func(i int, agent string) error {
+		return callAgent(agent, "/run", nil, &states[i])
+	})
+
+	state := &workloads.State{}
+	state.Init()
+	fmt.Println("Agents:")
+	for i := range states {
+		fmt.Printf("\t%s: %v operations\n", agents[i], states[i].Operations)
+		state.Merge(&states[i])
+	}
+	return state
+}
+
+// agent waits for the config of a coordinator, runs the workload when told to
+// and returns its state. Agents serve a single run.
+type agent struct {
+	// configure adjusts the config received from the coordinator to the
+	// agent, e.g. its local directories.
+	configure func(config *Config)
+
+	mux   *http.ServeMux
+	lock  sync.Mutex
+	bench *benchmark
+	done  chan struct{}
+}
+
+func newAgent() *agent {
+	a := &agent{mux: http.NewServeMux(), done: make(chan struct{})}
+	a.mux.HandleFunc("/prepare", a.prepare)
+	a.mux.HandleFunc("/run", a.run)
+	return a
+}
+
+func (a *agent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+func (a *agent) prepare(w http.ResponseWriter, r *http.Request) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.bench != nil {
+		http.Error(w, "agent is already prepared", http.StatusConflict)
+		return
+	}
+	config := Config{}
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if a.configure != nil {
+		a.configure(&config)
+	}
+	a.bench = newBenchmark(config)
+	log.Printf("Prepared client %d of %d", config.Workload.ClientIndex+1, config.Workload.ClientCount)
+}
+
+// run returns the state once all workers have stopped.
+func (a *agent) run(w http.ResponseWriter, r *http.Request) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.bench == nil {
+		http.Error(w, "agent is not prepared", http.StatusConflict)
+		return
+	}
+	select {
+	case <-a.done:
+		http.Error(w, "agent has already run", http.StatusConflict)
+		return
+	default:
+	}
+	a.bench.run()
+	a.bench.report()
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&a.bench.state); err != nil {
+		log.Print(err)
+	}
+	close(a.done)
+}
+
+// serveAgent serves an agent on the address until its run is done.
+func serveAgent(address string) {
+	a := newAgent()
+	server := &http.Server{Addr: address, Handler: a}
+	go func() {
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+	log.Printf("Agent listening on %s", address)
+	<-a.done
+	server.Shutdown(context.Background())
+}
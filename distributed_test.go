@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/couchbaselabs/blurr/databases"
+	"github.com/couchbaselabs/blurr/workloads"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestPartition(t *testing.T) {
+	config := Config{}
+	config.Workload.Operations = 10
+	config.Workload.Throughput = 8
+	config.Workload.QueryThroughput = 3
+	configs, err := partition(config, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][3]int64{{4, 3, 1}, {3, 3, 1}, {3, 2, 1}}
+	for i, c := range configs {
+		actual := [3]int64{c.Workload.Operations, int64(c.Workload.Throughput), int64(c.Workload.QueryThroughput)}
+		if actual != expected[i] || c.Workload.ClientIndex != i || c.Workload.ClientCount != 3 {
+			t.Errorf("agent %d: %v != %v", i, actual, expected[i])
+		}
+	}
+
+	config.Workload.Throughput = 0
+	if configs, err := partition(config, 3); err != nil || configs[2].Workload.Throughput != 0 {
+		t.Errorf("unlimited throughput: %v", err)
+	}
+	config.Workload.Throughput = 2
+	if _, err := partition(config, 3); err == nil {
+		t.Error("throughput lower than the number of agents was split")
+	}
+}
+
+func TestDistributedRun(t *testing.T) {
+	agents := make([]*agent, 2)
+	addresses := make([]string, 2)
+	directories := make([]string, 2)
+	for i := range agents {
+		directory := t.TempDir()
+		agents[i] = newAgent()
+		agents[i].configure = func(config *Config) {
+			config.Database.Bolt.Directory = directory
+		}
+		server := httptest.NewServer(agents[i])
+		defer server.Close()
+		addresses[i], directories[i] = strings.TrimPrefix(server.URL, "http://"), directory
+	}
+
+	// Agents write to their own files, keys of creates must not overlap.
+	config := Config{
+		Database: databases.Config{Driver: "Bolt", Name: "blurr", Table: "docs",
+			Bolt: databases.BoltConfig{NoSync: true}},
+		Workload: workloads.Config{Type: "Default", CreatePercentage: 100,
+			Operations: 2000, Workers: 2, ValueSize: 64},
+		Cluster: ClusterConfig{Agents: addresses},
+	}
+	state := coordinate(config)
+	if state.Events["Finished"].Before(state.Events["Started"]) {
+		t.Errorf("unexpected events: %v", state.Events)
+	}
+
+	operations, creates, values := int64(0), 0, int64(0)
+	keys := map[string]int{}
+	for i, a := range agents {
+		a.lock.Lock()
+		s := &a.bench.state
+		a.lock.Unlock()
+		operations += s.Operations
+		creates += len(s.Latency["Create"])
+		values += s.ValueSizes.Total()
+		if s.Errors["total"] != 0 || len(s.ErrorLatency) != 0 {
+			t.Errorf("agent %d: unexpected errors: %v", i, s.Errors)
+		}
+
+		db, err := bolt.Open(filepath.Join(directories[i], "blurr.db"), 0644, &bolt.Options{Timeout: time.Second})
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored := int64(0)
+		err = db.View(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte("docs")).ForEach(func(key, value []byte) error {
+				keys[string(key)]++
+				stored++
+				return nil
+			})
+		})
+		db.Close()
+		if err != nil || stored != s.Operations {
+			t.Errorf("agent %d: %d keys for %d operations (%v)", i, stored, s.Operations, err)
+		}
+	}
+	for key, count := range keys {
+		if count > 1 {
+			t.Errorf("key %s is created by %d agents", key, count)
+		}
+	}
+
+	if state.Operations != operations || operations < config.Workload.Operations {
+		t.Errorf("%d merged operations, %d operations of agents", state.Operations, operations)
+	}
+	if len(state.Latency["Create"]) != creates || creates == 0 {
+		t.Errorf("%d merged latency samples, %d samples of agents", len(state.Latency["Create"]), creates)
+	}
+	if state.ValueSizes.Total() != values || values != operations {
+		t.Errorf("%d merged value sizes, %d value sizes of agents", state.ValueSizes.Total(), values)
+	}
+	if state.Errors["total"] != 0 || len(state.ErrorLatency) != 0 {
+		t.Errorf("unexpected errors: %v", state.Errors)
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log"
 	"math/rand"
 	"runtime"
@@ -11,36 +13,103 @@ import (
 	"github.com/thomas-couchbase/blurr/workloads"
 )
 
-var config Config
-var database databases.Database
-var workload workloads.Workload
-var state workloads.State
-
-func init() {
-	config = ReadConfig()
+// benchmark is a run of the workload against the configured database.
+type benchmark struct {
+	config        Config
+	catalog       *databases.Catalog
+	database      databases.Database
+	probeDatabase databases.Database
+	workload      workloads.Workload
+	state         workloads.State
+}
 
-	switch config.Database.Driver {
+func newDatabase(driver string) databases.Database {
+	switch driver {
 	case "MongoDB":
-		database = &databases.MongoDB{}
+		return &databases.MongoDB{}
 	case "Couchbase":
-		database = &databases.Couchbase{}
+		return &databases.Couchbase{}
 	case "Cassandra":
-		database = &databases.Cassandra{}
+		return &databases.Cassandra{}
+	case "CQL":
+		return &databases.CQL{}
 	case "Tuq":
-		database = &databases.Tuq{}
-	default:
-		log.Fatal("Unsupported driver")
+		return &databases.Tuq{}
+	case "Redis":
+		return &databases.Redis{}
+	case "PostgreSQL":
+		return &databases.PostgreSQL{}
+	case "Bolt":
+		return &databases.Bolt{}
+	case "HTTP":
+		return &databases.HTTP{}
+	case "Remote":
+		return &databases.Remote{}
+	}
+	log.Fatal("Unsupported driver")
+	return nil
+}
+
+// provision creates or drops the views, indexes and schemas required by the
+// configured workload.
+func provision(command string, config Config) {
+	database := newDatabase(config.Database.Driver)
+	provisioner, ok := database.(databases.Provisioner)
+	if !ok {
+		log.Fatalf("%s driver does not support %s", config.Database.Driver, command)
+	}
+	database.Init(config.Database)
+
+	var err error
+	if command == "setup" {
+		err = provisioner.Setup(config.Workload.Indexes)
+	} else {
+		err = provisioner.Teardown(config.Workload.Indexes)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	database.Shutdown()
+	log.Printf("Finished %s of %v indexes", command, len(config.Workload.Indexes))
+}
+
+// newBenchmark loads the query catalog and initializes the drivers and the
+// workload.
+func newBenchmark(config Config) *benchmark {
+	b := &benchmark{catalog: LoadCatalog(&config)}
+	// Throughput is configured for all workers.
+	if config.Workload.Workers > 0 {
+		config.Workload.Throughput /= config.Workload.Workers
+	}
+	if config.Workload.QueryWorkers > 0 {
+		config.Workload.QueryThroughput /= config.Workload.QueryWorkers
+	}
+	b.config = config
+
+	database := newDatabase(config.Database.Driver)
+	if _, ok := database.(databases.Scanner); !ok && config.Workload.ScanPercentage > 0 {
+		log.Fatalf("%s driver does not support scans", config.Database.Driver)
+	}
+	if config.Database.Retry.MaxAttempts > 1 {
+		database = &databases.Retry{Database: database}
 	}
 
+	base := workloads.Default{
+		Config: config.Workload,
+		Sizes: workloads.NewDistribution(config.Workload.ValueSizeDistribution,
+			config.Workload.ValueSize),
+		TTLs:        workloads.NewDistribution(config.Workload.TTL, 0),
+		ScanLengths: workloads.NewDistribution(config.Workload.ScanLength, 100),
+	}
+
+	var workload workloads.Workload
 	switch config.Workload.Type {
 	case "Default":
-		workload = &workloads.Default{
-			Config: config.Workload,
-		}
+		workload = &base
 	case "HotSpot":
 		workload = &workloads.HotSpot{
 			Config:  config.Workload,
-			Default: workloads.Default{Config: config.Workload},
+			Default: base,
 		}
 	case "N1QL":
 		r := rand.New(rand.NewSource(0))
@@ -48,50 +117,105 @@ func init() {
 		workload = &workloads.N1QL{
 			Config:  config.Workload,
 			Zipf:    *zipf,
-			Default: workloads.Default{Config: config.Workload},
+			Catalog: b.catalog,
+			Default: base,
 		}
 	default:
 		log.Fatal("Unsupported workload")
 	}
 	workload.SetImplementation(workload)
+	b.workload = workload
 
 	database.Init(config.Database)
+	b.database = database
+	if config.Workload.VisibilityProbe {
+		b.probeDatabase = newDatabase(config.Database.Driver)
+		b.probeDatabase.Init(config.Database)
+	}
 
-	state = workloads.State{}
-	state.Records = config.Workload.Records
-	state.Init()
+	b.state.Records = config.Workload.Records
+	b.state.Init()
+	if config.Workload.Verify || config.Workload.Staleness {
+		b.state.Verifier = workloads.NewVerifier(config.Workload)
+	}
+	if config.Workload.ValidateQueries {
+		b.state.Model = workloads.NewModel(b.catalog)
+		for record := int64(1); record <= config.Workload.Records; record++ {
+			b.state.Model.Add(workload.GenerateNewKey(record))
+		}
+	}
+	return b
 }
 
 func main() {
+	command, config := ReadConfig()
+	switch command {
+	case "run":
+		b := newBenchmark(config)
+		b.run()
+		b.report()
+	case "coordinator":
+		LoadCatalog(&config)
+		coordinate(config).ReportSummary()
+	case "agent":
+		serveAgent(flag.Arg(1))
+	default:
+		LoadCatalog(&config)
+		provision(command, config)
+	}
+}
+
+// run runs the workload until all operations are done or RunTime elapses.
+func (b *benchmark) run() {
 	runtime.GOMAXPROCS(runtime.NumCPU())
 
+	config, state := b.config, &b.state
 	wg := sync.WaitGroup{}
 	wgStats := sync.WaitGroup{}
 
 	state.Events["Started"] = time.Now()
 	for worker := 0; worker < config.Workload.Workers; worker++ {
 		wg.Add(1)
-		go workload.RunCRUDWorkload(database, &state, &wg)
+		go b.workload.RunCRUDWorkload(b.database, state, &wg)
 	}
 
 	for worker := 0; worker < config.Workload.QueryWorkers; worker++ {
 		wg.Add(1)
-		go workload.RunQueryWorkload(database, &state, &wg)
+		go b.workload.RunQueryWorkload(b.database, state, &wg)
 	}
 
 	wgStats.Add(2)
 	go state.ReportThroughput(config.Workload, &wgStats)
-	go state.MeasureLatency(database, workload, config.Workload, &wgStats)
+	go state.MeasureLatency(b.database, b.workload, config.Workload, &wgStats)
+	if b.probeDatabase != nil {
+		wgStats.Add(1)
+		go state.ProbeVisibility(b.database, b.probeDatabase, b.workload, config.Workload, &wgStats)
+	}
 
 	if config.Workload.RunTime > 0 {
-		time.Sleep(time.Duration(config.Workload.RunTime) * time.Second)
-		log.Println("Shutting down workers")
-	} else {
-		wg.Wait()
-		wgStats.Wait()
+		timer := time.AfterFunc(time.Duration(config.Workload.RunTime)*time.Second, func() {
+			log.Println("Shutting down workers")
+			state.Stop()
+		})
+		defer timer.Stop()
 	}
+	wg.Wait()
+	state.Stop()
+	wgStats.Wait()
 
-	database.Shutdown()
+	b.database.Shutdown()
+	if b.probeDatabase != nil {
+		b.probeDatabase.Shutdown()
+	}
 	state.Events["Finished"] = time.Now()
-	state.ReportSummary()
+}
+
+func (b *benchmark) report() {
+	if durable, ok := b.database.(databases.Durable); ok && durable.Durability() != "" {
+		fmt.Printf("Durability:\n\t%s\n", durable.Durability())
+	}
+	b.state.ReportSummary()
+	if reporter, ok := b.database.(databases.Reporter); ok {
+		reporter.ReportSummary()
+	}
 }
@@ -0,0 +1,123 @@
+package stats
+
+import (
+	"math"
+	"sort"
+	"sync"
+)
+
+// Relative error of values reported by Histogram.
+const Precision = 0.01
+
+var logBase = math.Log1p(Precision)
+
+// Histogram is a log-bucketed, mergeable histogram safe for concurrent use.
+// The zero value is ready to use.
+type Histogram struct {
+	mu     sync.Mutex
+	Counts map[int]int64
+	Count  int64
+	Sum    float64
+	Min    float64
+	Max    float64
+}
+
+func bucket(value float64) int {
+	if value <= 0 {
+		return math.MinInt32
+	}
+	return int(math.Ceil(math.Log(value) / logBase))
+}
+
+func bucketValue(b int) float64 {
+	if b == math.MinInt32 {
+		return 0
+	}
+	return math.Exp(float64(b) * logBase)
+}
+
+func (h *Histogram) Record(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.Counts == nil {
+		h.Counts = map[int]int64{}
+	}
+	if h.Count == 0 || value < h.Min {
+		h.Min = value
+	}
+	if h.Count == 0 || value > h.Max {
+		h.Max = value
+	}
+	h.Counts[bucket(value)]++
+	h.Count++
+	h.Sum += value
+}
+
+func (h *Histogram) Merge(other *Histogram) {
+	other.mu.Lock()
+	defer other.mu.Unlock()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if other.Count == 0 {
+		return
+	}
+	if h.Counts == nil {
+		h.Counts = map[int]int64{}
+	}
+	if h.Count == 0 || other.Min < h.Min {
+		h.Min = other.Min
+	}
+	if h.Count == 0 || other.Max > h.Max {
+		h.Max = other.Max
+	}
+	for b, count := range other.Counts {
+		h.Counts[b] += count
+	}
+	h.Count += other.Count
+	h.Sum += other.Sum
+}
+
+func (h *Histogram) Percentile(p float64) float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.Count == 0 {
+		return 0
+	}
+	buckets := make([]int, 0, len(h.Counts))
+	for b := range h.Counts {
+		buckets = append(buckets, b)
+	}
+	sort.Ints(buckets)
+
+	rank := int64(math.Ceil(p * float64(h.Count)))
+	if rank < 1 {
+		rank = 1
+	}
+	seen := int64(0)
+	for _, b := range buckets {
+		seen += h.Counts[b]
+		if seen >= rank {
+			return math.Max(h.Min, math.Min(h.Max, bucketValue(b)))
+		}
+	}
+	return h.Max
+}
+
+func (h *Histogram) Mean() float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / float64(h.Count)
+}
+
+func (h *Histogram) Total() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.Count
+}
@@ -0,0 +1,36 @@
+package stats
+
+import (
+	"math"
+	"testing"
+)
+
+func TestHistogramPercentile(t *testing.T) {
+	h := Histogram{}
+	for i := 1; i <= 1000; i++ {
+		h.Record(float64(i))
+	}
+	for _, p := range []float64{0.5, 0.9, 0.99} {
+		expected := p * 1000
+		if value := h.Percentile(p); math.Abs(value-expected) > expected*Precision {
+			t.Errorf("%vth percentile: %v != %v", p*100, value, expected)
+		}
+	}
+	if h.Mean() != 500.5 {
+		t.Errorf("mean: %v != 500.5", h.Mean())
+	}
+}
+
+func TestHistogramMerge(t *testing.T) {
+	a, b := Histogram{}, Histogram{}
+	a.Record(1)
+	b.Record(0)
+	b.Record(100)
+	a.Merge(&b)
+	if a.Total() != 3 || a.Min != 0 || a.Max != 100 {
+		t.Errorf("merged histogram: count=%v min=%v max=%v", a.Total(), a.Min, a.Max)
+	}
+	if a.Percentile(1) != 100 {
+		t.Errorf("max percentile: %v != 100", a.Percentile(1))
+	}
+}
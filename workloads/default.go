@@ -8,6 +8,7 @@ import (
 	"math/rand"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/couchbaselabs/blurr/databases"
@@ -18,6 +19,9 @@ const BatchSize int = 100
 type Default struct {
 	Config       Config
 	DeletedItems int64
+	Sizes        Distribution
+	TTLs         Distribution
+	ScanLengths  Distribution
 	i            Workload
 }
 
@@ -38,38 +42,103 @@ func RandString(key string, expectedLength int) string {
 	return randString
 }
 
+// ValueSize returns the number of payload bytes in a generated value: string
+// contents, field names and fixed-width numbers, without encoding overhead.
+func ValueSize(value interface{}) int {
+	switch v := value.(type) {
+	case string:
+		return len(v)
+	case []byte:
+		return len(v)
+	case int16:
+		return 2
+	case float64:
+		return 8
+	case []int16:
+		return 2 * len(v)
+	case map[string]interface{}:
+		size := 0
+		for field, fieldValue := range v {
+			size += len(field) + ValueSize(fieldValue)
+		}
+		return size
+	}
+	return 0
+}
+
 func (w *Default) SetImplementation(i Workload) {
 	w.i = i
 }
 
 func (w *Default) GenerateNewKey(currentRecords int64) string {
-	strCurrentRecords := strconv.FormatInt(currentRecords, 10)
+	strCurrentRecords := strconv.FormatInt(w.Config.newRecord(currentRecords), 10)
 	return Hash(strCurrentRecords)
 }
 
 func (w *Default) GenerateExistingKey(currentRecords int64) string {
-	randRecord := 1 + rand.Int63n(currentRecords-w.DeletedItems)
-	randRecord += w.DeletedItems
+	deleted, total := w.Config.population(currentRecords, atomic.LoadInt64(&w.DeletedItems))
+	randRecord := 1 + rand.Int63n(total-deleted)
+	randRecord += deleted
 	strRandRecord := strconv.FormatInt(randRecord, 10)
 	return Hash(strRandRecord)
 }
 
 func (w *Default) GenerateKeyForRemoval() string {
-	w.DeletedItems++
-	keyForRemoval := strconv.FormatInt(w.DeletedItems, 10)
+	keyForRemoval := strconv.FormatInt(w.Config.removedRecord(atomic.AddInt64(&w.DeletedItems, 1)), 10)
 	return Hash(keyForRemoval)
 }
 
+func (w *Default) GenerateValueSize() int {
+	if w.Sizes == nil {
+		return w.Config.ValueSize
+	}
+	return w.Sizes.Next()
+}
+
 func (w *Default) GenerateValue(key string, size int) map[string]interface{} {
 	return map[string]interface{}{
 		key: RandString(key, size),
 	}
 }
 
+func (w *Default) GenerateBinaryValue(key string, size int) []byte {
+	return []byte(RandString(key, size))
+}
+
+// GeneratePayload returns either a document or a raw binary value depending
+// on the configured ValueType.
+func (w *Default) GeneratePayload(key string, size int) interface{} {
+	if w.Config.ValueType == "Binary" {
+		return w.i.GenerateBinaryValue(key, size)
+	}
+	return w.i.GenerateValue(key, size)
+}
+
+func (w *Default) GenerateTTL() int {
+	if w.TTLs == nil {
+		return 0
+	}
+	return w.TTLs.Next()
+}
+
 func (w *Default) GenerateQueryArgs(key string) []interface{} {
 	return []interface{}{}
 }
 
+// GenerateScanOptions returns the options of the next scan. Scans read 100
+// documents unless a ScanLength distribution is configured.
+func (w *Default) GenerateScanOptions() databases.ScanOptions {
+	length := 100
+	if w.ScanLengths != nil {
+		length = w.ScanLengths.Next()
+	}
+	return databases.ScanOptions{
+		Length:     length,
+		PageSize:   w.Config.ScanPageSize,
+		Pagination: w.Config.ScanPagination,
+	}
+}
+
 func (w *Default) PrepareBatch() []string {
 	operations := make([]string, 0, BatchSize)
 	for i := 0; i < w.Config.CreatePercentage; i++ {
@@ -84,6 +153,9 @@ func (w *Default) PrepareBatch() []string {
 	for i := 0; i < w.Config.DeletePercentage; i++ {
 		operations = append(operations, "d")
 	}
+	for i := 0; i < w.Config.ScanPercentage; i++ {
+		operations = append(operations, "s")
+	}
 	if len(operations) != BatchSize {
 		log.Fatal("Wrong workload configuration: sum of percentages is not equal 100")
 	}
@@ -119,36 +191,81 @@ func (w *Default) PrepareQuerySeq(size int64) chan string {
 	return seq
 }
 
+func (w *Default) expectedValue(key string) func(size int, stamp Stamp) interface{} {
+	return func(size int, stamp Stamp) interface{} {
+		return withStamp(w.i.GeneratePayload(key, size), stamp)
+	}
+}
+
 func (w *Default) DoBatch(db databases.Database, state *State, seq chan string) {
 	for i := 0; i < BatchSize; i++ {
 		op := <-seq
-		if state.Operations < w.Config.Operations {
+		if state.countOperation(w.Config) {
 			var err error
-			state.Operations++
+			var t0 time.Time
 			switch op {
 			case "c":
-				state.Records++
-				key := w.i.GenerateNewKey(state.Records)
-				value := w.i.GenerateValue(key, w.Config.ValueSize)
-				err = db.Create(key, value)
+				key := w.i.GenerateNewKey(atomic.AddInt64(&state.Records, 1))
+				size := w.i.GenerateValueSize()
+				value, stamp := state.Verifier.Stamp(w.i.GeneratePayload(key, size))
+				state.ValueSizes.Record(float64(ValueSize(value)))
+				ttl := w.i.GenerateTTL()
+				state.SetExpiry(key, ttl)
+				t0 = time.Now()
+				err = db.Create(key, value, ttl)
+				if err == nil {
+					state.Verifier.Acknowledge(key, stamp, size, op)
+					state.Model.Add(key)
+				}
 			case "r":
-				key := w.i.GenerateExistingKey(state.Records)
-				err = db.Read(key)
+				var value interface{}
+				key := w.i.GenerateExistingKey(atomic.LoadInt64(&state.Records))
+				t0 = time.Now()
+				value, err = db.Read(key)
+				if databases.ClassOf(err) == databases.NotFound && state.CountExpiredRead(key) {
+					err = nil
+				} else {
+					state.Verifier.CheckRead(key, value, err, t0, w.expectedValue(key))
+				}
 			case "u":
-				key := w.i.GenerateExistingKey(state.Records)
-				value := w.i.GenerateValue(key, w.Config.ValueSize)
-				err = db.Update(key, value)
+				key := w.i.GenerateExistingKey(atomic.LoadInt64(&state.Records))
+				size := w.i.GenerateValueSize()
+				value, stamp := state.Verifier.Stamp(w.i.GeneratePayload(key, size))
+				state.ValueSizes.Record(float64(ValueSize(value)))
+				ttl := w.i.GenerateTTL()
+				state.SetExpiry(key, ttl)
+				t0 = time.Now()
+				err = db.Update(key, value, ttl)
+				if err == nil {
+					state.Verifier.Acknowledge(key, stamp, size, op)
+				}
 			case "d":
 				key := w.i.GenerateKeyForRemoval()
+				state.SetExpiry(key, 0)
+				t0 = time.Now()
 				err = db.Delete(key)
+				if err == nil {
+					state.Verifier.AcknowledgeDelete(key)
+					state.Model.Remove(key)
+				}
+			case "s":
+				key := w.i.GenerateExistingKey(atomic.LoadInt64(&state.Records))
+				options := w.i.GenerateScanOptions()
+				t0 = time.Now()
+				var scanned int
+				scanned, err = db.(databases.Scanner).Scan(key, options)
+				state.ScanLengths.Record(float64(scanned))
 			case "q":
-				key := w.i.GenerateExistingKey(state.Records)
+				key := w.i.GenerateExistingKey(atomic.LoadInt64(&state.Records))
 				args := w.i.GenerateQueryArgs(key)
-				err = db.Query(key, args)
+				t0 = time.Now()
+				var rows []map[string]interface{}
+				rows, err = db.Query(key, args)
+				state.RecordQuery(args, len(rows), err, msSince(t0, time.Now()))
 			}
 			if err != nil {
-				state.Errors[op]++
-				state.Errors["total"]++
+				latency := float64(time.Since(t0)/time.Microsecond) / 1000
+				state.RecordError(op, err, latency)
 			}
 		}
 	}
@@ -157,7 +274,7 @@ func (w *Default) DoBatch(db databases.Database, state *State, seq chan string)
 func (w *Default) runWorkload(database databases.Database,
 	state *State, wg *sync.WaitGroup, targetBatchTimeF float64, seq chan string) {
 
-	for state.Operations < w.Config.Operations {
+	for state.running(w.Config) {
 		t0 := time.Now()
 		w.i.DoBatch(database, state, seq)
 		t1 := time.Now()
@@ -167,7 +284,7 @@ func (w *Default) runWorkload(database databases.Database,
 			actualBatchTime := t1.Sub(t0)
 			sleepTime := (targetBatchTime - actualBatchTime)
 			if sleepTime > 0 {
-				time.Sleep(time.Duration(sleepTime))
+				state.sleep(sleepTime)
 			}
 		}
 	}
@@ -0,0 +1,187 @@
+package workloads
+
+import (
+	"bufio"
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type DistributionConfig struct {
+	Type   string
+	Min    int
+	Max    int
+	Mean   float64
+	StdDev float64
+	Skew   float64
+	File   string
+}
+
+type Distribution interface {
+	Next() int
+}
+
+func NewDistribution(config DistributionConfig, fallback int) Distribution {
+	switch config.Type {
+	case "", "Constant":
+		if config.Mean > 0 {
+			return &Constant{int(config.Mean)}
+		}
+		return &Constant{fallback}
+	case "Uniform":
+		if config.Max < config.Min {
+			log.Fatal("Wrong distribution configuration: 'Max' is less than 'Min'")
+		}
+		return &Uniform{config.Min, config.Max}
+	case "Normal":
+		return &Normal{config}
+	case "LogNormal":
+		if config.Mean <= 0 {
+			log.Fatal("Wrong distribution configuration: LogNormal requires positive 'Mean'")
+		}
+		return &LogNormal{config}
+	case "Zipf":
+		return NewZipfDistribution(config)
+	case "Histogram":
+		return NewHistogramDistribution(config)
+	}
+	log.Fatalf("Unsupported distribution: %s", config.Type)
+	return nil
+}
+
+func clamp(value float64, config DistributionConfig) int {
+	value = math.Max(value, float64(config.Min))
+	if config.Max > 0 {
+		value = math.Min(value, float64(config.Max))
+	}
+	return int(math.Max(0, math.Floor(value+0.5)))
+}
+
+type Constant struct {
+	Value int
+}
+
+func (d *Constant) Next() int {
+	return d.Value
+}
+
+type Uniform struct {
+	Min, Max int
+}
+
+func (d *Uniform) Next() int {
+	return d.Min + rand.Intn(d.Max-d.Min+1)
+}
+
+type Normal struct {
+	Config DistributionConfig
+}
+
+func (d *Normal) Next() int {
+	return clamp(rand.NormFloat64()*d.Config.StdDev+d.Config.Mean, d.Config)
+}
+
+// LogNormal treats Mean as the median and StdDev as the standard deviation
+// of the natural logarithm.
+type LogNormal struct {
+	Config DistributionConfig
+}
+
+func (d *LogNormal) Next() int {
+	mu := math.Log(d.Config.Mean)
+	return clamp(math.Exp(mu+rand.NormFloat64()*d.Config.StdDev), d.Config)
+}
+
+type ZipfDistribution struct {
+	mu   sync.Mutex
+	zipf *rand.Zipf
+	min  int
+}
+
+func NewZipfDistribution(config DistributionConfig) *ZipfDistribution {
+	if config.Max <= config.Min {
+		log.Fatal("Wrong distribution configuration: Zipf requires 'Max' greater than 'Min'")
+	}
+	skew := config.Skew
+	if skew <= 1 {
+		skew = 1.1
+	}
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return &ZipfDistribution{
+		zipf: rand.NewZipf(r, skew, 1, uint64(config.Max-config.Min)),
+		min:  config.Min,
+	}
+}
+
+func (d *ZipfDistribution) Next() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.min + int(d.zipf.Uint64())
+}
+
+// HistogramDistribution samples values from an empirical histogram. Each line
+// of the file holds a value and its weight separated by whitespace; empty lines
+// and lines starting with '#' are ignored.
+type HistogramDistribution struct {
+	Values  []int
+	Weights []float64
+}
+
+func NewHistogramDistribution(config DistributionConfig) *HistogramDistribution {
+	file, err := os.Open(config.File)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+
+	d := &HistogramDistribution{}
+	total := float64(0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			log.Fatalf("Wrong histogram line: %q", line)
+		}
+		value, err := strconv.Atoi(fields[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+		weight, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if weight <= 0 {
+			continue
+		}
+		total += weight
+		d.Values = append(d.Values, value)
+		d.Weights = append(d.Weights, total)
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+	if len(d.Values) == 0 {
+		log.Fatalf("Empty histogram: %s", config.File)
+	}
+	return d
+}
+
+func (d *HistogramDistribution) Next() int {
+	total := d.Weights[len(d.Weights)-1]
+	target := rand.Float64() * total
+	i := sort.SearchFloat64s(d.Weights, target)
+	if i == len(d.Values) {
+		i--
+	}
+	return d.Values[i]
+}
@@ -3,23 +3,26 @@ package workloads
 import (
 	"math/rand"
 	"strconv"
+	"sync/atomic"
 )
 
+// HotSpot shares the counter of deleted items of Default, whose
+// GenerateKeyForRemoval it uses.
 type HotSpot struct {
-	Config       Config
-	DeletedItems int64
+	Config Config
 	Default
 }
 
 func (w *HotSpot) GenerateExistingKey(currentRecords int64) string {
 	var randRecord int64
-	total_records := currentRecords - w.DeletedItems
+	deleted, total := w.Config.population(currentRecords, atomic.LoadInt64(&w.DeletedItems))
+	total_records := total - deleted
 	hot_records := total_records * w.Config.HotDataPercentage / 100
 	cold_records := total_records - hot_records
 	if rand.Intn(100) < w.Config.HotSpotAccessPercentage {
-		randRecord = 1 + w.DeletedItems + cold_records + rand.Int63n(hot_records)
+		randRecord = 1 + deleted + cold_records + rand.Int63n(hot_records)
 	} else {
-		randRecord = 1 + w.DeletedItems + rand.Int63n(cold_records)
+		randRecord = 1 + deleted + rand.Int63n(cold_records)
 	}
 	strRandRecord := strconv.FormatInt(randRecord, 10)
 	return Hash(strRandRecord)
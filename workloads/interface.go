@@ -12,9 +12,16 @@ type Config struct {
 	ReadPercentage          int
 	UpdatePercentage        int
 	DeletePercentage        int
+	ScanPercentage          int
 	Records                 int64
 	Operations              int64
 	ValueSize               int
+	ValueSizeDistribution   DistributionConfig
+	ValueType               string
+	TTL                     DistributionConfig
+	ScanLength              DistributionConfig
+	ScanPagination          string
+	ScanPageSize            int
 	Workers                 int
 	QueryWorkers            int
 	Throughput              int
@@ -23,6 +30,15 @@ type Config struct {
 	HotSpotAccessPercentage int
 	RunTime                 int
 	Indexes                 []string
+	QueryWeights            map[string]int
+	ValidateQueries         bool
+	Verify                  bool
+	VerifyGracePeriod       int
+	Staleness               bool
+	VisibilityProbe         bool
+	VisibilityTimeout       int
+	ClientIndex             int
+	ClientCount             int
 }
 
 type Workload interface {
@@ -34,10 +50,20 @@ type Workload interface {
 
 	GenerateKeyForRemoval() string
 
+	GenerateValueSize() int
+
 	GenerateValue(key string, size int) map[string]interface{}
 
+	GenerateBinaryValue(key string, size int) []byte
+
+	GeneratePayload(key string, size int) interface{}
+
+	GenerateTTL() int
+
 	GenerateQueryArgs(key string) []interface{}
 
+	GenerateScanOptions() databases.ScanOptions
+
 	PrepareBatch() []string
 
 	PrepareSeq(size int64) chan string
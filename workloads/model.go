@@ -0,0 +1,256 @@
+package workloads
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/couchbaselabs/blurr/databases"
+)
+
+// fields generates all document fields known to the query catalog.
+func fields(key string) map[string]interface{} {
+	alphabet := build_alphabet(key)
+	record := make(map[string]interface{}, len(Generators))
+	for field, generator := range Generators {
+		record[field] = generator(alphabet)
+	}
+	return record
+}
+
+func compare(value interface{}, op string, operand interface{}) bool {
+	a, aok := toFloat(value)
+	b, bok := toFloat(operand)
+	if !aok || !bok {
+		return op == "=" && reflect.DeepEqual(value, operand)
+	}
+	switch op {
+	case "=":
+		return a == b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return false
+}
+
+func matches(record map[string]interface{}, e *databases.Expectation, values map[string]interface{}) bool {
+	for _, condition := range e.Filter {
+		operand := condition.Value
+		if condition.Param != "" {
+			operand = values[condition.Param]
+		}
+		if !compare(record[condition.Field], condition.Op, operand) {
+			return false
+		}
+	}
+	return true
+}
+
+type validation struct {
+	Checked, Mismatches int64
+}
+
+// Model is an in-memory copy of the indexed fields of all live documents
+// generated by the N1QL workload. It is used to validate query results
+// against the expectations defined in the query catalog.
+type Model struct {
+	Catalog *databases.Catalog
+
+	mu      sync.RWMutex
+	records map[string]map[string]interface{}
+	results map[string]*validation
+}
+
+func NewModel(catalog *databases.Catalog) *Model {
+	return &Model{
+		Catalog: catalog,
+		records: map[string]map[string]interface{}{},
+		results: map[string]*validation{},
+	}
+}
+
+func (m *Model) Add(key string) {
+	if m == nil {
+		return
+	}
+	r := fields(key)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records[key] = r
+}
+
+func (m *Model) Remove(key string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.records, key)
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-6*math.Max(1, math.Max(math.Abs(a), math.Abs(b)))
+}
+
+// distinctValue extracts the value of a row returned by a distinct query:
+// view rows have a "key", MongoDB rows a "value" and N1QL rows a single field.
+func distinctValue(row map[string]interface{}) interface{} {
+	for _, field := range []string{"key", "value"} {
+		if value, ok := row[field]; ok {
+			return value
+		}
+	}
+	for _, value := range row {
+		return value
+	}
+	return nil
+}
+
+func normalizeScalar(value interface{}) interface{} {
+	if f, ok := toFloat(value); ok {
+		return f
+	}
+	return value
+}
+
+func (m *Model) expected(e *databases.Expectation, values map[string]interface{}) (int, float64, float64, float64, map[interface{}]bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	count, sum := 0, float64(0)
+	min, max := math.Inf(1), math.Inf(-1)
+	distinct := map[interface{}]bool{}
+	for _, r := range m.records {
+		if !matches(r, e, values) {
+			continue
+		}
+		if e.Kind == "distinct" {
+			distinct[normalizeScalar(r[e.Field])] = true
+			continue
+		}
+		count++
+		if value, ok := toFloat(r[e.Field]); ok {
+			sum += value
+			min = math.Min(min, value)
+			max = math.Max(max, value)
+		}
+	}
+	return count, sum, min, max, distinct
+}
+
+func (m *Model) check(e *databases.Expectation, values map[string]interface{}, rows []map[string]interface{}) bool {
+	count, sum, min, max, distinct := m.expected(e, values)
+	limit := m.Catalog.Limit
+
+	switch e.Kind {
+	case "rows":
+		if count > limit {
+			count = limit
+		}
+		return len(rows) == count
+	case "stats":
+		if count == 0 {
+			if len(rows) == 0 {
+				return true
+			}
+			// Aggregations without grouping (CQL) return a row with zero count.
+			empty, ok := toFloat(rows[0]["count"])
+			return len(rows) == 1 && ok && empty == 0
+		}
+		if len(rows) != 1 {
+			return false
+		}
+		for field, expected := range map[string]float64{
+			"count": float64(count), "sum": sum, "min": min, "max": max,
+		} {
+			actual, ok := toFloat(rows[0][field])
+			if !ok || !almostEqual(actual, expected) {
+				return false
+			}
+		}
+		return true
+	case "distinct":
+		for _, row := range rows {
+			if !distinct[normalizeScalar(distinctValue(row))] {
+				return false
+			}
+		}
+		expectedRows := len(distinct)
+		if expectedRows > limit {
+			expectedRows = limit
+		}
+		return len(rows) >= expectedRows
+	}
+	return true
+}
+
+// Validate compares query results with the model and counts mismatches per
+// query. Queries without an expectation are not validated.
+func (m *Model) Validate(args []interface{}, rows []map[string]interface{}) bool {
+	if m == nil || len(args) == 0 {
+		return true
+	}
+	q, values, err := m.Catalog.Prepare(args)
+	if err != nil || q.Expect == nil {
+		return true
+	}
+	valid := m.check(q.Expect, values, rows)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := m.results[q.Name]
+	if result == nil {
+		result = &validation{}
+		m.results[q.Name] = result
+	}
+	result.Checked++
+	if !valid {
+		result.Mismatches++
+	}
+	return valid
+}
+
+func (m *Model) ReportSummary() {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.results) == 0 {
+		return
+	}
+	indexes := make([]string, 0, len(m.results))
+	for index := range m.results {
+		indexes = append(indexes, index)
+	}
+	sort.Strings(indexes)
+
+	fmt.Println("Query validation:")
+	for _, index := range indexes {
+		result := m.results[index]
+		fmt.Printf("\t%v: %v checked, %v mismatches\n",
+			index, result.Checked, result.Mismatches)
+	}
+}
@@ -7,36 +7,40 @@ import (
 	"math/rand"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
+
+	"github.com/couchbaselabs/blurr/databases"
 )
 
 type N1QL struct {
 	Config       Config
 	DeletedItems int64
 	Zipf         rand.Zipf
+	Catalog      *databases.Catalog
 	Default
 }
 
 func (w *N1QL) GenerateNewKey(currentRecords int64) string {
-	return fmt.Sprintf("%012d", currentRecords)
+	return fmt.Sprintf("%012d", w.Config.newRecord(currentRecords))
 }
 
 func (w *N1QL) GenerateExistingKey(currentRecords int64) string {
 	var randRecord int64
-	total_records := currentRecords - w.DeletedItems
+	deleted, total := w.Config.population(currentRecords, atomic.LoadInt64(&w.DeletedItems))
+	total_records := total - deleted
 	hot_records := total_records * w.Config.HotDataPercentage / 100
 	cold_records := total_records - hot_records
 	if rand.Intn(100) < w.Config.HotSpotAccessPercentage {
-		randRecord = 1 + w.DeletedItems + cold_records + rand.Int63n(hot_records)
+		randRecord = 1 + deleted + cold_records + rand.Int63n(hot_records)
 	} else {
-		randRecord = 1 + w.DeletedItems + rand.Int63n(cold_records)
+		randRecord = 1 + deleted + rand.Int63n(cold_records)
 	}
 	return fmt.Sprintf("%012d", randRecord)
 }
 
 func (w *N1QL) GenerateKeyForRemoval() string {
-	w.DeletedItems++
-	return fmt.Sprintf("%012d", w.DeletedItems)
+	return fmt.Sprintf("%012d", w.Config.removedRecord(atomic.AddInt64(&w.DeletedItems, 1)))
 }
 
 func reverse(s string) string {
@@ -156,6 +160,19 @@ func (w *N1QL) RandSize(size int) int {
 	}
 }
 
+// GenerateValueSize keeps the original N1QL body size model (normal with Zipf
+// outliers) unless a value size distribution is configured explicitly.
+func (w *N1QL) GenerateValueSize() int {
+	if w.Config.ValueSizeDistribution.Type == "" {
+		return OVERHEAD + w.RandSize(w.Config.ValueSize)
+	}
+	size := w.Default.GenerateValueSize()
+	if size < OVERHEAD {
+		size = OVERHEAD
+	}
+	return size
+}
+
 func (w *N1QL) GenerateValue(key string, size int) map[string]interface{} {
 	if size < OVERHEAD {
 		log.Fatalf("Wrong workload configuration: minimal value size is %v", OVERHEAD)
@@ -210,126 +227,39 @@ func (w *N1QL) GenerateValue(key string, size int) map[string]interface{} {
 		"achievements": build_achievements(alphabet),
 		"gmtime":       build_gmtime(alphabet),
 		"year":         build_year(alphabet),
-		"body":         RandString(key, w.RandSize(size)),
+		"body":         RandString(key, size-OVERHEAD),
 	}
 }
 
-func (w *N1QL) GenerateQueryArgs(key string) []interface{} {
-	alphabet := build_alphabet(key)
-	index := w.Config.Indexes[rand.Intn(len(w.Config.Indexes))]
+// queryWeight returns the configured weight of the index, 1 by default.
+func (w *N1QL) queryWeight(index string) int {
+	if weight, ok := w.Config.QueryWeights[index]; ok {
+		return weight
+	}
+	return 1
+}
 
-	switch index {
-	case "name_and_street_by_city":
-		return []interface{}{
-			index,
-			build_city(alphabet),
-		}
-	case "name_and_email_by_county":
-		return []interface{}{
-			index,
-			build_county(alphabet),
-		}
-	case "achievements_by_realm":
-		return []interface{}{
-			index,
-			build_realm(alphabet),
-		}
-	case "name_by_coins":
-		return []interface{}{
-			index,
-			build_coins(alphabet),
-		}
-	case "email_by_achievement_and_category":
-		return []interface{}{
-			index,
-			build_achievements(alphabet),
-			build_category(alphabet),
-		}
-	case "street_by_year_and_coins":
-		return []interface{}{
-			index,
-			build_year(alphabet),
-			build_coins(alphabet),
-		}
-	case "coins_stats_by_state_and_year":
-		return []interface{}{
-			index,
-			build_state(alphabet),
-			build_year(alphabet),
-		}
-	case "coins_stats_by_gmtime_and_year":
-		return []interface{}{
-			index,
-			build_gmtime(alphabet),
-			build_year(alphabet),
-		}
-	case "coins_stats_by_full_state_and_year":
-		return []interface{}{
-			index,
-			build_full_state(alphabet),
-			build_year(alphabet),
-		}
-	case "name_and_email_and_street_and_achievements_and_coins_by_city":
-		return []interface{}{
-			index,
-			build_city(alphabet),
-		}
-	case "street_and_name_and_email_and_achievement_and_coins_by_county":
-		return []interface{}{
-			index,
-			build_county(alphabet),
-		}
-	case "category_name_and_email_and_street_and_gmtime_and_year_by_country":
-		return []interface{}{
-			index,
-			build_country(alphabet),
-		}
-	case "calc_by_city":
-		return []interface{}{
-			index,
-			build_city(alphabet),
-		}
-	case "calc_by_county":
-		return []interface{}{
-			index,
-			build_county(alphabet),
-		}
-	case "calc_by_realm":
-		return []interface{}{
-			index,
-			build_realm(alphabet),
-		}
-	case "body_by_city":
-		return []interface{}{
-			index,
-			build_city(alphabet),
-		}
-	case "body_by_realm":
-		return []interface{}{
-			index,
-			build_realm(alphabet),
-		}
-	case "body_by_country":
-		return []interface{}{
-			index,
-			build_country(alphabet),
-		}
-	case "distinct_states":
-		return []interface{}{
-			index,
-			"state.f",
-		}
-	case "distinct_full_states":
-		return []interface{}{
-			index,
-			"full_state.f",
-		}
-	case "distinct_years":
-		return []interface{}{
-			index,
-			"year",
+// PickIndex selects one of the configured indexes with probability
+// proportional to its weight.
+func (w *N1QL) PickIndex() string {
+	total := 0
+	for _, index := range w.Config.Indexes {
+		total += w.queryWeight(index)
+	}
+	n := rand.Intn(total)
+	for _, index := range w.Config.Indexes {
+		if n -= w.queryWeight(index); n < 0 {
+			return index
 		}
 	}
-	log.Fatalf("Uknown index: %s", index)
-	return nil
+	return w.Config.Indexes[len(w.Config.Indexes)-1]
+}
+
+func (w *N1QL) GenerateQueryArgs(key string) []interface{} {
+	index := w.PickIndex()
+	q, ok := w.Catalog.Get(index)
+	if !ok {
+		log.Fatalf("Uknown index: %s", index)
+	}
+	return QueryArgs(q, key)
 }
@@ -0,0 +1,41 @@
+package workloads
+
+// Clients sharing a key space (agents of a coordinator) interleave their
+// records: record numbers above the initial Records are assigned round-robin
+// by ClientIndex, and so are the records removed by deletes. Clients count
+// their own creates and deletes, the records of all clients are estimated
+// assuming they progress at the same rate.
+
+func (c Config) partition() (index, count int64) {
+	if c.ClientCount <= 1 {
+		return 0, 1
+	}
+	return int64(c.ClientIndex), int64(c.ClientCount)
+}
+
+// newRecord returns the record number of the create which brings the records
+// of the client to currentRecords.
+func (c Config) newRecord(currentRecords int64) int64 {
+	if currentRecords <= c.Records {
+		return currentRecords
+	}
+	index, count := c.partition()
+	return c.Records + (currentRecords-c.Records-1)*count + index + 1
+}
+
+// removedRecord returns the record number of the given delete of the client.
+func (c Config) removedRecord(deletedItems int64) int64 {
+	index, count := c.partition()
+	return (deletedItems-1)*count + index + 1
+}
+
+// population returns the number of records deleted and created by all
+// clients.
+func (c Config) population(currentRecords, deletedItems int64) (deleted, total int64) {
+	_, count := c.partition()
+	total = currentRecords
+	if currentRecords > c.Records {
+		total = c.Records + (currentRecords-c.Records)*count
+	}
+	return deletedItems * count, total
+}
@@ -0,0 +1,80 @@
+package workloads
+
+import (
+	"fmt"
+
+	"github.com/couchbaselabs/blurr/databases"
+)
+
+// Generators derive the fields of N1QL workload documents from the alphabet
+// of their key. They are referenced by name from query catalogs.
+var Generators = map[string]func(alphabet string) interface{}{
+	"name":    func(a string) interface{} { return build_name(a) },
+	"email":   func(a string) interface{} { return build_email(a) },
+	"street":  func(a string) interface{} { return build_street(a) },
+	"city":    func(a string) interface{} { return build_city(a) },
+	"county":  func(a string) interface{} { return build_county(a) },
+	"realm":   func(a string) interface{} { return build_realm(a) },
+	"country": func(a string) interface{} { return build_country(a) },
+	"state":   func(a string) interface{} { return build_state(a) },
+	"full_state": func(a string) interface{} {
+		return build_full_state(a)
+	},
+	"coins":        func(a string) interface{} { return build_coins(a) },
+	"category":     func(a string) interface{} { return build_category(a) },
+	"year":         func(a string) interface{} { return build_year(a) },
+	"gmtime":       func(a string) interface{} { return build_gmtime(a) },
+	"achievements": func(a string) interface{} { return build_achievements(a) },
+	"achievement": func(a string) interface{} {
+		if achievements := build_achievements(a); len(achievements) > 0 {
+			return achievements[0]
+		}
+		return int16(0)
+	},
+}
+
+// ValidateCatalog checks that the catalog only refers to known generators.
+func ValidateCatalog(catalog *databases.Catalog) error {
+	for _, q := range catalog.Queries {
+		for _, param := range q.Params {
+			if _, ok := Generators[param.Generator]; !ok {
+				return fmt.Errorf("%s: unknown generator: %s", q.Name, param.Generator)
+			}
+		}
+		if q.Expect == nil {
+			continue
+		}
+		fields := []string{}
+		if q.Expect.Field != "" {
+			fields = append(fields, q.Expect.Field)
+		}
+		for _, condition := range q.Expect.Filter {
+			fields = append(fields, condition.Field)
+		}
+		for _, field := range fields {
+			if _, ok := Generators[field]; !ok {
+				return fmt.Errorf("%s: unknown field: %s", q.Name, field)
+			}
+		}
+	}
+	return nil
+}
+
+func generateParam(param databases.Param, alphabet string) interface{} {
+	value := Generators[param.Generator](alphabet)
+	if coins, ok := value.(float64); ok && param.Scale != 0 {
+		return coins * param.Scale
+	}
+	return value
+}
+
+// QueryArgs returns the name of the query followed by its parameters
+// generated for the key.
+func QueryArgs(q *databases.QueryDefinition, key string) []interface{} {
+	alphabet := build_alphabet(key)
+	args := []interface{}{q.Name}
+	for _, param := range q.Params {
+		args = append(args, generateParam(param, alphabet))
+	}
+	return args
+}
@@ -5,16 +5,46 @@ import (
 	"math"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/couchbaselabs/blurr/databases"
+	"github.com/couchbaselabs/blurr/stats"
 )
 
+// State is shared by workers, Operations and Records are updated atomically.
 type State struct {
 	Operations, Records int64
 	Errors              map[string]int
 	Events              map[string]time.Time
 	Latency             map[string][]float64
+	ValueSizes          *stats.Histogram
+	ScanLengths         *stats.Histogram
+	ExpiredReads        int64
+	ErrorLatency        map[string]map[databases.ErrorClass]*stats.Histogram
+	Verifier            *Verifier
+	Model               *Model
+	Visibility          *stats.Histogram
+	VisibilityTimeouts  int64
+	VisibilityErrors    int64
+	Queries             map[string]*QueryStats
+
+	errorLock  sync.Mutex
+	queryLock  sync.Mutex
+	expiryLock sync.Mutex
+	expiries   map[string]time.Time
+	pruned     time.Time
+	stop       chan struct{}
+	stopOnce   sync.Once
+}
+
+var opNames = map[string]string{
+	"c": "Create",
+	"r": "Read",
+	"u": "Update",
+	"d": "Delete",
+	"q": "Query",
+	"s": "Scan",
 }
 
 func (state *State) Init() {
@@ -26,6 +56,217 @@ func (state *State) Init() {
 		"Update": []float64{},
 		"Delete": []float64{},
 		"Query":  []float64{},
+		"Scan":   []float64{},
+	}
+	state.ValueSizes = &stats.Histogram{}
+	state.ScanLengths = &stats.Histogram{}
+	state.Visibility = &stats.Histogram{}
+	state.expiries = map[string]time.Time{}
+	state.stop = make(chan struct{})
+	state.ErrorLatency = map[string]map[databases.ErrorClass]*stats.Histogram{}
+	state.Queries = map[string]*QueryStats{}
+}
+
+// QueryStats summarizes executions of a named query: latency and number of
+// returned rows of successful queries and the number of errors.
+type QueryStats struct {
+	Latency *stats.Histogram
+	Rows    *stats.Histogram
+	Count   int64
+	Errors  int64
+}
+
+// RecordQuery records an execution of the query identified by its arguments.
+func (state *State) RecordQuery(args []interface{}, rows int, err error, latency float64) {
+	name := "Query"
+	if len(args) > 0 {
+		if index, ok := args[0].(string); ok {
+			name = index
+		}
+	}
+
+	state.queryLock.Lock()
+	query := state.Queries[name]
+	if query == nil {
+		query = &QueryStats{Latency: &stats.Histogram{}, Rows: &stats.Histogram{}}
+		state.Queries[name] = query
+	}
+	query.Count++
+	if err != nil {
+		query.Errors++
+	}
+	state.queryLock.Unlock()
+
+	if err == nil {
+		query.Latency.Record(latency)
+		query.Rows.Record(float64(rows))
+	}
+}
+
+// RecordError counts a failed operation and its latency by operation and
+// error class.
+func (state *State) RecordError(op string, err error, latency float64) {
+	class := databases.ClassOf(err)
+
+	state.errorLock.Lock()
+	state.Errors[op]++
+	state.Errors["total"]++
+	if state.ErrorLatency[op] == nil {
+		state.ErrorLatency[op] = map[databases.ErrorClass]*stats.Histogram{}
+	}
+	histogram := state.ErrorLatency[op][class]
+	if histogram == nil {
+		histogram = &stats.Histogram{}
+		state.ErrorLatency[op][class] = histogram
+	}
+	state.errorLock.Unlock()
+
+	histogram.Record(latency)
+}
+
+// maxExpiries bounds the number of keys with expiry remembered by a state.
+var maxExpiries = 1 << 20
+
+// SetExpiry remembers when a key written with the given TTL expires, zero TTL
+// or removal of the key forgets about it. When maxExpiries keys are
+// remembered, expired keys are forgotten (at most once per second) and new
+// keys are not remembered until there is room again.
+func (state *State) SetExpiry(key string, ttl int) {
+	state.expiryLock.Lock()
+	defer state.expiryLock.Unlock()
+
+	if ttl <= 0 {
+		if len(state.expiries) > 0 {
+			delete(state.expiries, key)
+		}
+		return
+	}
+	now := time.Now()
+	if _, ok := state.expiries[key]; !ok && len(state.expiries) >= maxExpiries {
+		if now.Sub(state.pruned) >= time.Second {
+			state.pruned = now
+			for k, deadline := range state.expiries {
+				if !now.Before(deadline) {
+					delete(state.expiries, k)
+				}
+			}
+		}
+		if len(state.expiries) >= maxExpiries {
+			return
+		}
+	}
+	state.expiries[key] = now.Add(time.Duration(ttl) * time.Second)
+}
+
+// CountExpiredRead reports whether a missing key is explained by its expiry
+// and counts such reads separately from errors.
+func (state *State) CountExpiredRead(key string) bool {
+	state.expiryLock.Lock()
+	defer state.expiryLock.Unlock()
+
+	deadline, ok := state.expiries[key]
+	if ok && !time.Now().Before(deadline) {
+		state.ExpiredReads++
+		return true
+	}
+	return false
+}
+
+// Stop makes workers and measurement loops return after their current
+// operation, e.g. when the run time elapses.
+func (state *State) Stop() {
+	if state.stop != nil {
+		state.stopOnce.Do(func() { close(state.stop) })
+	}
+}
+
+// running reports whether operations remain and the state is not stopped.
+func (state *State) running(config Config) bool {
+	select {
+	case <-state.stop:
+		return false
+	default:
+		return atomic.LoadInt64(&state.Operations) < config.Operations
+	}
+}
+
+// countOperation counts an operation unless all operations are done.
+func (state *State) countOperation(config Config) bool {
+	for {
+		operations := atomic.LoadInt64(&state.Operations)
+		if operations >= config.Operations {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&state.Operations, operations, operations+1) {
+			return true
+		}
+	}
+}
+
+// sleep waits for d and returns false if the state is stopped meanwhile.
+func (state *State) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-state.stop:
+		return false
+	}
+}
+
+// Merge adds the results of another run, e.g. of an agent, to the state.
+// Latency samples are combined, the run spans both runs. Verification and
+// query validation results are not merged, they require a single client.
+func (state *State) Merge(other *State) {
+	state.Operations += other.Operations
+	state.ExpiredReads += other.ExpiredReads
+	state.VisibilityTimeouts += other.VisibilityTimeouts
+	state.VisibilityErrors += other.VisibilityErrors
+	for event, t := range other.Events {
+		current, ok := state.Events[event]
+		if !ok || (event == "Started" && t.Before(current)) || (event != "Started" && t.After(current)) {
+			state.Events[event] = t
+		}
+	}
+	for op, latency := range other.Latency {
+		state.Latency[op] = append(state.Latency[op], latency...)
+	}
+	mergeHistogram(state.ValueSizes, other.ValueSizes)
+	mergeHistogram(state.ScanLengths, other.ScanLengths)
+	mergeHistogram(state.Visibility, other.Visibility)
+
+	for op, count := range other.Errors {
+		state.Errors[op] += count
+	}
+	for op, classes := range other.ErrorLatency {
+		if state.ErrorLatency[op] == nil {
+			state.ErrorLatency[op] = map[databases.ErrorClass]*stats.Histogram{}
+		}
+		for class, histogram := range classes {
+			if state.ErrorLatency[op][class] == nil {
+				state.ErrorLatency[op][class] = &stats.Histogram{}
+			}
+			state.ErrorLatency[op][class].Merge(histogram)
+		}
+	}
+
+	for name, q := range other.Queries {
+		query := state.Queries[name]
+		if query == nil {
+			query = &QueryStats{Latency: &stats.Histogram{}, Rows: &stats.Histogram{}}
+			state.Queries[name] = query
+		}
+		query.Count += q.Count
+		query.Errors += q.Errors
+		mergeHistogram(query.Latency, q.Latency)
+		mergeHistogram(query.Rows, q.Rows)
+	}
+}
+
+func mergeHistogram(h, other *stats.Histogram) {
+	if other != nil {
+		h.Merge(other)
 	}
 }
 
@@ -34,12 +275,18 @@ func (state *State) ReportThroughput(config Config, wg *sync.WaitGroup) {
 	opsDone := int64(0)
 	samples := 1
 	fmt.Println("Benchmark started:")
-	for state.Operations < config.Operations {
-		time.Sleep(10 * time.Second)
-		throughput := (state.Operations - opsDone) / 10
-		opsDone = state.Operations
+	for state.running(config) {
+		if !state.sleep(10 * time.Second) {
+			return
+		}
+		operations := atomic.LoadInt64(&state.Operations)
+		throughput := (operations - opsDone) / 10
+		opsDone = operations
+		state.errorLock.Lock()
+		errors := state.Errors["total"]
+		state.errorLock.Unlock()
 		fmt.Printf("%6v seconds: %10v ops/sec; total operations: %v; total errors: %v\n",
-			samples*10, throughput, opsDone, state.Errors["total"])
+			samples*10, throughput, opsDone, errors)
 		samples++
 	}
 }
@@ -48,21 +295,28 @@ func (state *State) MeasureLatency(database databases.Database,
 	workload Workload, config Config, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	for state.Operations < config.Operations {
+	for state.running(config) {
 		if config.CreatePercentage > 0 {
-			state.Operations++
-			state.Records++
-			key := workload.GenerateNewKey(state.Records)
-			value := workload.GenerateValue(key, config.ValueSize)
+			atomic.AddInt64(&state.Operations, 1)
+			key := workload.GenerateNewKey(atomic.AddInt64(&state.Records, 1))
+			size := workload.GenerateValueSize()
+			value, stamp := state.Verifier.Stamp(workload.GeneratePayload(key, size))
+			state.ValueSizes.Record(float64(ValueSize(value)))
+			ttl := workload.GenerateTTL()
+			state.SetExpiry(key, ttl)
 			t0 := time.Now()
-			database.Create(key, value)
+			err := database.Create(key, value, ttl)
 			t1 := time.Now()
+			if err == nil {
+				state.Verifier.Acknowledge(key, stamp, size, "c")
+				state.Model.Add(key)
+			}
 			latency := float64(t1.Sub(t0)/time.Microsecond) / 1000
 			state.Latency["Create"] = append(state.Latency["Create"], latency)
 		}
 		if config.ReadPercentage > 0 {
-			state.Operations++
-			key := workload.GenerateExistingKey(state.Records)
+			atomic.AddInt64(&state.Operations, 1)
+			key := workload.GenerateExistingKey(atomic.LoadInt64(&state.Records))
 			t0 := time.Now()
 			database.Read(key)
 			t1 := time.Now()
@@ -70,35 +324,115 @@ func (state *State) MeasureLatency(database databases.Database,
 			state.Latency["Read"] = append(state.Latency["Read"], latency)
 		}
 		if config.UpdatePercentage > 0 {
-			state.Operations++
-			key := workload.GenerateExistingKey(state.Records)
-			value := workload.GenerateValue(key, config.ValueSize)
+			atomic.AddInt64(&state.Operations, 1)
+			key := workload.GenerateExistingKey(atomic.LoadInt64(&state.Records))
+			size := workload.GenerateValueSize()
+			value, stamp := state.Verifier.Stamp(workload.GeneratePayload(key, size))
+			state.ValueSizes.Record(float64(ValueSize(value)))
+			ttl := workload.GenerateTTL()
+			state.SetExpiry(key, ttl)
 			t0 := time.Now()
-			database.Update(key, value)
+			err := database.Update(key, value, ttl)
 			t1 := time.Now()
+			if err == nil {
+				state.Verifier.Acknowledge(key, stamp, size, "u")
+			}
 			latency := float64(t1.Sub(t0)/time.Microsecond) / 1000
 			state.Latency["Update"] = append(state.Latency["Update"], latency)
 		}
 		if config.DeletePercentage > 0 {
-			state.Operations++
+			atomic.AddInt64(&state.Operations, 1)
 			key := workload.GenerateKeyForRemoval()
+			state.SetExpiry(key, 0)
 			t0 := time.Now()
-			database.Delete(key)
+			err := database.Delete(key)
 			t1 := time.Now()
+			if err == nil {
+				state.Verifier.AcknowledgeDelete(key)
+				state.Model.Remove(key)
+			}
 			latency := float64(t1.Sub(t0)/time.Microsecond) / 1000
 			state.Latency["Delete"] = append(state.Latency["Delete"], latency)
 		}
+		if config.ScanPercentage > 0 {
+			atomic.AddInt64(&state.Operations, 1)
+			key := workload.GenerateExistingKey(atomic.LoadInt64(&state.Records))
+			options := workload.GenerateScanOptions()
+			t0 := time.Now()
+			database.(databases.Scanner).Scan(key, options)
+			t1 := time.Now()
+			latency := float64(t1.Sub(t0)/time.Microsecond) / 1000
+			state.Latency["Scan"] = append(state.Latency["Scan"], latency)
+		}
 		if config.QueryWorkers > 0 {
-			state.Operations++
-			key := workload.GenerateExistingKey(state.Records)
+			atomic.AddInt64(&state.Operations, 1)
+			key := workload.GenerateExistingKey(atomic.LoadInt64(&state.Records))
 			args := workload.GenerateQueryArgs(key)
 			t0 := time.Now()
-			database.Query(key, args)
+			rows, err := database.Query(key, args)
 			t1 := time.Now()
+			if err == nil {
+				state.Model.Validate(args, rows)
+			}
 			latency := float64(t1.Sub(t0)/time.Microsecond) / 1000
+			state.RecordQuery(args, len(rows), err, latency)
 			state.Latency["Query"] = append(state.Latency["Query"], latency)
 		}
-		time.Sleep(time.Second)
+		state.sleep(time.Second)
+	}
+}
+
+// Polling interval of visibility probes, doubled after every miss.
+const (
+	minProbeInterval = time.Millisecond
+	maxProbeInterval = 50 * time.Millisecond
+)
+
+// ProbeVisibility writes a probe document through one connection and polls it
+// through another one until it becomes visible, once per second. Time to
+// visibility is measured from acknowledgement of the write. Probes failing
+// with errors other than not found are counted separately and abandoned.
+func (state *State) ProbeVisibility(writer, reader databases.Database,
+	workload Workload, config Config, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	timeout := time.Duration(config.VisibilityTimeout) * time.Millisecond
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+
+	for probe := 1; state.running(config); probe++ {
+		key := fmt.Sprintf("probe-%d", probe)
+		if config.ClientCount > 1 {
+			key = fmt.Sprintf("probe-%d-%d", config.ClientIndex, probe)
+		}
+		value := workload.GeneratePayload(key, workload.GenerateValueSize())
+		if err := writer.Create(key, value, 0); err != nil {
+			state.sleep(time.Second)
+			continue
+		}
+		t0 := time.Now()
+		for interval := minProbeInterval; ; {
+			_, err := reader.Read(key)
+			if err == nil {
+				state.Visibility.Record(msSince(t0, time.Now()))
+				break
+			}
+			if databases.ClassOf(err) != databases.NotFound {
+				state.VisibilityErrors++
+				break
+			}
+			if time.Since(t0) > timeout {
+				state.VisibilityTimeouts++
+				break
+			}
+			time.Sleep(interval)
+			if interval *= 2; interval > maxProbeInterval {
+				interval = maxProbeInterval
+			}
+		}
+		writer.Delete(key)
+		state.sleep(time.Second)
 	}
 }
 
@@ -124,7 +458,7 @@ func calcMean(data []float64) float64 {
 }
 
 func (state *State) ReportSummary() {
-	for _, op := range []string{"Create", "Read", "Update", "Delete", "Query"} {
+	for _, op := range []string{"Create", "Read", "Update", "Delete", "Scan", "Query"} {
 		if len(state.Latency[op]) > 0 {
 			fmt.Printf("%v latency:\n", op)
 			for _, percentile := range []float64{0.8, 0.9, 0.95, 0.99} {
@@ -136,15 +470,93 @@ func (state *State) ReportSummary() {
 		}
 	}
 
+	if state.ScanLengths.Total() > 0 {
+		fmt.Println("Scan length:")
+		fmt.Printf("\tMean: %.1f documents\n", state.ScanLengths.Mean())
+		fmt.Printf("\t99th percentile: %.0f documents\n", state.ScanLengths.Percentile(0.99))
+	}
+
+	state.reportQueries()
+
+	if state.ValueSizes.Total() > 0 {
+		fmt.Println("Value size:")
+		for _, percentile := range []float64{0.5, 0.8, 0.9, 0.95, 0.99} {
+			value := state.ValueSizes.Percentile(percentile)
+			fmt.Printf("\t%vth percentile: %.0f bytes\n", percentile*100, value)
+		}
+		fmt.Printf("\tMean: %.0f bytes\n", state.ValueSizes.Mean())
+		fmt.Printf("\tMax: %.0f bytes\n", state.ValueSizes.Max)
+	}
+
+	if state.ExpiredReads > 0 {
+		fmt.Printf("Reads of expired keys:\n\t%v\n", state.ExpiredReads)
+	}
+
+	if state.Verifier != nil {
+		state.Verifier.ReportSummary()
+	}
+	if state.Model != nil {
+		state.Model.ReportSummary()
+	}
+
+	reportDistribution("Time to visibility", state.Visibility)
+	if state.VisibilityTimeouts > 0 {
+		fmt.Printf("\tTimeouts: %v\n", state.VisibilityTimeouts)
+	}
+	if state.VisibilityErrors > 0 {
+		fmt.Printf("\tErrors: %v\n", state.VisibilityErrors)
+	}
+
 	if len(state.Errors) > 0 {
 		fmt.Println("Errors:")
 		fmt.Printf("\tCreate : %v\n", state.Errors["c"])
 		fmt.Printf("\tRead   : %v\n", state.Errors["r"])
 		fmt.Printf("\tUpdate : %v\n", state.Errors["u"])
 		fmt.Printf("\tDelete : %v\n", state.Errors["d"])
+		fmt.Printf("\tScan   : %v\n", state.Errors["s"])
 		fmt.Printf("\tQuery  : %v\n", state.Errors["q"])
 		fmt.Printf("\tTotal  : %v\n", state.Errors["total"])
+
+		fmt.Println("Errors by class:")
+		for _, op := range []string{"c", "r", "u", "d", "s", "q"} {
+			for _, class := range databases.ErrorClasses {
+				histogram := state.ErrorLatency[op][class]
+				if histogram == nil {
+					continue
+				}
+				fmt.Printf("\t%-6v %-13v: %v (mean: %.2f ms, 99th percentile: %.2f ms)\n",
+					opNames[op], class, histogram.Total(), histogram.Mean(),
+					histogram.Percentile(0.99))
+			}
+		}
 	}
 	fmt.Printf("Time elapsed:\n\t%v\n",
 		state.Events["Finished"].Sub(state.Events["Started"]))
 }
+
+func (state *State) reportQueries() {
+	if len(state.Queries) == 0 {
+		return
+	}
+	names := make([]string, 0, len(state.Queries))
+	for name := range state.Queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	elapsed := state.Events["Finished"].Sub(state.Events["Started"]).Seconds()
+
+	fmt.Println("Queries:")
+	for _, name := range names {
+		query := state.Queries[name]
+		fmt.Printf("\t%v:\n", name)
+		fmt.Printf("\t\tThroughput: %.1f queries/sec (%v queries, %v errors)\n",
+			float64(query.Count)/elapsed, query.Count, query.Errors)
+		if query.Latency.Total() == 0 {
+			continue
+		}
+		fmt.Printf("\t\tLatency: 50th %.2f ms, 90th %.2f ms, 99th %.2f ms, mean %.2f ms\n",
+			query.Latency.Percentile(0.5), query.Latency.Percentile(0.9),
+			query.Latency.Percentile(0.99), query.Latency.Mean())
+		fmt.Printf("\t\tRows: mean %.1f, max %.0f\n", query.Rows.Mean(), query.Rows.Max)
+	}
+}
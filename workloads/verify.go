@@ -0,0 +1,277 @@
+package workloads
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbaselabs/blurr/databases"
+	"github.com/couchbaselabs/blurr/stats"
+)
+
+// Documents written in verify mode carry their version in VersionField and,
+// when staleness is measured, the write time in TimestampField. Binary values
+// are prefixed with "<version>:" or "<version>:<timestamp>:".
+const (
+	VersionField   = "_version"
+	TimestampField = "_written"
+)
+
+// Stamp identifies a write: a sequential version and the write time in
+// nanoseconds since epoch (zero unless staleness is measured).
+type Stamp struct {
+	Version   int64
+	Timestamp int64
+}
+
+type written struct {
+	stamp   Stamp
+	size    int
+	op      string
+	acked   time.Time
+	deleted bool
+}
+
+// Verifier tracks the latest acknowledged write per key and checks that reads
+// observe it. A read that misses a write acknowledged less than GracePeriod
+// before the read started is counted as stale, later ones as lost writes.
+//
+// With Timestamps enabled it also records staleness of every verified read
+// (zero for up-to-date reads, otherwise time since the missed write was
+// acknowledged) and the age of returned documents.
+type Verifier struct {
+	GracePeriod time.Duration
+	Timestamps  bool
+	Staleness   *stats.Histogram
+	DataAge     *stats.Histogram
+
+	mu      sync.Mutex
+	version int64
+	keys    map[string]*written
+
+	Verified           int64
+	StaleReads         int64
+	CorruptedReads     int64
+	LostCreates        int64
+	LostUpdates        int64
+	ResurrectedDeletes int64
+}
+
+func NewVerifier(config Config) *Verifier {
+	gracePeriod := config.VerifyGracePeriod
+	if gracePeriod == 0 {
+		gracePeriod = 1000
+	}
+	return &Verifier{
+		GracePeriod: time.Duration(gracePeriod) * time.Millisecond,
+		Timestamps:  config.Staleness,
+		Staleness:   &stats.Histogram{},
+		DataAge:     &stats.Histogram{},
+		keys:        map[string]*written{},
+	}
+}
+
+// Stamp embeds a new version (and write time) into the value. The value is
+// returned unchanged when verification is disabled.
+func (v *Verifier) Stamp(value interface{}) (interface{}, Stamp) {
+	if v == nil {
+		return value, Stamp{}
+	}
+	v.mu.Lock()
+	v.version++
+	stamp := Stamp{Version: v.version}
+	v.mu.Unlock()
+	if v.Timestamps {
+		stamp.Timestamp = time.Now().UnixNano()
+	}
+	return withStamp(value, stamp), stamp
+}
+
+func withStamp(value interface{}, stamp Stamp) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		v[VersionField] = stamp.Version
+		if stamp.Timestamp != 0 {
+			v[TimestampField] = stamp.Timestamp
+		}
+		return v
+	case []byte:
+		prefix := strconv.FormatInt(stamp.Version, 10) + ":"
+		if stamp.Timestamp != 0 {
+			prefix += strconv.FormatInt(stamp.Timestamp, 10) + ":"
+		}
+		return append([]byte(prefix), v...)
+	}
+	return value
+}
+
+func toInt64(value interface{}) int64 {
+	switch v := value.(type) {
+	case float64:
+		return int64(v)
+	case int64:
+		return v
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	}
+	return 0
+}
+
+func stampOf(value interface{}) Stamp {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return Stamp{toInt64(v[VersionField]), toInt64(v[TimestampField])}
+	case []byte:
+		fields := strings.SplitN(string(v), ":", 3)
+		stamp := Stamp{}
+		if len(fields) > 1 {
+			stamp.Version, _ = strconv.ParseInt(fields[0], 10, 64)
+		}
+		if len(fields) > 2 {
+			stamp.Timestamp, _ = strconv.ParseInt(fields[1], 10, 64)
+		}
+		return stamp
+	}
+	return Stamp{}
+}
+
+// normalize makes values returned by different drivers comparable with
+// generated ones.
+func normalize(value interface{}) interface{} {
+	if raw, ok := value.([]byte); ok {
+		return string(raw)
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil
+	}
+	var normalized interface{}
+	json.Unmarshal(encoded, &normalized)
+	return normalized
+}
+
+func (v *Verifier) Acknowledge(key string, stamp Stamp, size int, op string) {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.keys[key] = &written{stamp: stamp, size: size, op: op, acked: time.Now()}
+}
+
+func (v *Verifier) AcknowledgeDelete(key string) {
+	if v == nil {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.keys[key] = &written{op: "d", acked: time.Now(), deleted: true}
+}
+
+// CheckRead compares the result of a read started at the given time with the
+// latest write acknowledged before it. The expected function regenerates the
+// value written with the given size and stamp.
+func (v *Verifier) CheckRead(key string, value interface{}, err error, start time.Time,
+	expected func(size int, stamp Stamp) interface{}) {
+	if v == nil {
+		return
+	}
+	notFound := databases.ClassOf(err) == databases.NotFound
+	if err != nil && !notFound {
+		return
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	last, ok := v.keys[key]
+	if !ok || !last.acked.Before(start) {
+		return
+	}
+	v.Verified++
+	overdue := start.Sub(last.acked) > v.GracePeriod
+
+	missed := false
+	switch {
+	case last.deleted:
+		missed = !notFound
+	case notFound:
+		missed = true
+	default:
+		stamp := stampOf(value)
+		if stamp.Version < last.stamp.Version {
+			missed = true
+		} else if stamp.Version == last.stamp.Version {
+			if !reflect.DeepEqual(normalize(value), normalize(expected(last.size, stamp))) {
+				v.CorruptedReads++
+			}
+		}
+		if stamp.Timestamp > 0 {
+			v.DataAge.Record(msSince(time.Unix(0, stamp.Timestamp), start))
+		}
+	}
+
+	if v.Timestamps {
+		if missed {
+			v.Staleness.Record(msSince(last.acked, start))
+		} else {
+			v.Staleness.Record(0)
+		}
+	}
+	if !missed {
+		return
+	}
+	if !overdue {
+		v.StaleReads++
+		return
+	}
+	switch last.op {
+	case "c":
+		v.LostCreates++
+	case "u":
+		v.LostUpdates++
+	case "d":
+		v.ResurrectedDeletes++
+	}
+}
+
+func msSince(t0, t1 time.Time) float64 {
+	return float64(t1.Sub(t0)/time.Microsecond) / 1000
+}
+
+func (v *Verifier) ReportSummary() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	fmt.Println("Verification:")
+	fmt.Printf("\tVerified reads      : %v\n", v.Verified)
+	fmt.Printf("\tStale reads         : %v\n", v.StaleReads)
+	fmt.Printf("\tCorrupted reads     : %v\n", v.CorruptedReads)
+	fmt.Printf("\tLost creates        : %v\n", v.LostCreates)
+	fmt.Printf("\tLost updates        : %v\n", v.LostUpdates)
+	fmt.Printf("\tResurrected deletes : %v\n", v.ResurrectedDeletes)
+
+	if v.Timestamps {
+		reportDistribution("Staleness", v.Staleness)
+		reportDistribution("Data age", v.DataAge)
+	}
+}
+
+func reportDistribution(name string, histogram *stats.Histogram) {
+	if histogram.Total() == 0 {
+		return
+	}
+	fmt.Printf("%v:\n", name)
+	for _, percentile := range []float64{0.5, 0.9, 0.99, 0.999} {
+		value := histogram.Percentile(percentile)
+		fmt.Printf("\t%vth percentile: %.2f ms\n", percentile*100, value)
+	}
+	fmt.Printf("\tMean: %.2f ms\n", histogram.Mean())
+	fmt.Printf("\tMax: %.2f ms\n", histogram.Max)
+}
@@ -1,9 +1,18 @@
 package workloads
 
 import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
 	"math/rand"
+	"os"
 	"reflect"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/couchbaselabs/blurr/databases"
 )
 
 var defaultWorkload Workload
@@ -27,6 +36,42 @@ func TestExistingKey(t *testing.T) {
 	}
 }
 
+func TestClientPartitions(t *testing.T) {
+	const clients, creates = 3, 4
+	partitioned := config
+	partitioned.Records = 10
+	partitioned.ClientCount = clients
+
+	created, removed := map[string]int{}, map[string]int{}
+	for index := 0; index < clients; index++ {
+		partitioned.ClientIndex = index
+		workload := &Default{Config: partitioned}
+		for records := partitioned.Records + 1; records <= partitioned.Records+creates; records++ {
+			created[workload.GenerateNewKey(records)]++
+			removed[workload.GenerateKeyForRemoval()]++
+		}
+		if index == 0 && workload.GenerateNewKey(partitioned.Records) != Hash("10") {
+			t.Error("initial records are shared by clients")
+		}
+	}
+	for record := 1; record <= clients*creates; record++ {
+		if key := Hash(strconv.Itoa(int(partitioned.Records) + record)); created[key] != 1 {
+			t.Errorf("record %d is created %d times", int(partitioned.Records)+record, created[key])
+		}
+		if key := Hash(strconv.Itoa(record)); removed[key] != 1 {
+			t.Errorf("record %d is removed %d times", record, removed[key])
+		}
+	}
+
+	// Reads span the records of all clients but skip their deletes.
+	partitioned.ClientIndex = 1
+	workload := &Default{Config: partitioned, DeletedItems: 2}
+	deleted, total := partitioned.population(partitioned.Records+creates, workload.DeletedItems)
+	if deleted != 2*clients || total != partitioned.Records+clients*creates {
+		t.Errorf("unexpected population: %d deleted, %d total", deleted, total)
+	}
+}
+
 func TestN1QLDoc(t *testing.T) {
 	workload := N1QL{Config: config}
 	new_doc := workload.GenerateValue("000000000020", OVERHEAD)
@@ -89,6 +134,338 @@ func TestN1QLDoc(t *testing.T) {
 	}
 }
 
+func TestDistributions(t *testing.T) {
+	histogram, err := ioutil.TempFile("", "sizes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(histogram.Name())
+	histogram.WriteString("# size weight\n512 1\n1024 3\n")
+	histogram.Close()
+
+	configs := []DistributionConfig{
+		{Type: "Uniform", Min: 100, Max: 200},
+		{Type: "Normal", Min: 100, Max: 200, Mean: 150, StdDev: 50},
+		{Type: "LogNormal", Min: 100, Max: 200, Mean: 150, StdDev: 1},
+		{Type: "Zipf", Min: 100, Max: 200, Skew: 1.4},
+	}
+	for _, config := range configs {
+		distribution := NewDistribution(config, 0)
+		for i := 0; i < 1000; i++ {
+			if size := distribution.Next(); size < config.Min || size > config.Max {
+				t.Errorf("%s: %v is out of [%v, %v]", config.Type, size, config.Min, config.Max)
+			}
+		}
+	}
+
+	if size := NewDistribution(DistributionConfig{}, 2048).Next(); size != 2048 {
+		t.Errorf("Constant: %v != 2048", size)
+	}
+
+	distribution := NewDistribution(DistributionConfig{Type: "Histogram", File: histogram.Name()}, 0)
+	counts := map[int]int{}
+	for i := 0; i < 1000; i++ {
+		counts[distribution.Next()]++
+	}
+	if len(counts) != 2 || counts[1024] < counts[512] {
+		t.Errorf("Histogram: unexpected samples %v", counts)
+	}
+}
+
+func TestN1QLValueSize(t *testing.T) {
+	workload := N1QL{Config: Config{ValueSizeDistribution: DistributionConfig{Type: "Constant"}}}
+	workload.Sizes = &Constant{OVERHEAD + 100}
+	size := workload.GenerateValueSize()
+	if body := workload.GenerateValue("000000000020", size)["body"].(string); len(body) != 100 {
+		t.Errorf("body size: %v != 100", len(body))
+	}
+
+	workload.Sizes = &Constant{100}
+	if size := workload.GenerateValueSize(); size != OVERHEAD {
+		t.Errorf("value size: %v != %v", size, OVERHEAD)
+	}
+}
+
+func TestBinaryPayload(t *testing.T) {
+	workload := &Default{Config: Config{ValueType: "Binary"}}
+	workload.SetImplementation(workload)
+	payload, ok := workload.GeneratePayload("key", 256).([]byte)
+	if !ok || len(payload) != 256 {
+		t.Errorf("unexpected payload: %T(%v)", payload, payload)
+	}
+}
+
+func TestRecordError(t *testing.T) {
+	state := State{}
+	state.Init()
+	state.RecordError("r", databases.ErrNotFound, 1.5)
+	state.RecordError("r", errors.New("unclassified"), 2.5)
+
+	if state.Errors["r"] != 2 || state.Errors["total"] != 2 {
+		t.Errorf("unexpected error counts: %v", state.Errors)
+	}
+	for _, class := range []databases.ErrorClass{databases.NotFound, databases.ServerError} {
+		if histogram := state.ErrorLatency["r"][class]; histogram == nil || histogram.Total() != 1 {
+			t.Errorf("%s: expected one error", class)
+		}
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	workload := &Default{Config: config}
+	workload.SetImplementation(workload)
+	verifier := NewVerifier(Config{VerifyGracePeriod: 100})
+
+	value, v1 := verifier.Stamp(workload.GeneratePayload("a", 64))
+	verifier.Acknowledge("a", v1, 64, "c")
+	_, v2 := verifier.Stamp(workload.GeneratePayload("a", 64))
+	verifier.Acknowledge("a", v2, 64, "u")
+	verifier.AcknowledgeDelete("b")
+
+	now := time.Now().Add(time.Millisecond)
+	later := now.Add(time.Second)
+	expected := workload.expectedValue("a")
+
+	verifier.CheckRead("a", value, nil, now, expected)
+	verifier.CheckRead("a", value, nil, later, expected)
+	verifier.CheckRead("a", expected(64, v2), nil, later, expected)
+	verifier.CheckRead("a", expected(32, v2), nil, later, expected)
+	verifier.CheckRead("b", map[string]interface{}{}, nil, later, expected)
+	verifier.CheckRead("b", nil, databases.ErrNotFound, later, expected)
+	verifier.CheckRead("c", nil, databases.ErrNotFound, later, expected)
+
+	if verifier.Verified != 6 || verifier.StaleReads != 1 || verifier.LostUpdates != 1 ||
+		verifier.CorruptedReads != 1 || verifier.ResurrectedDeletes != 1 {
+		t.Errorf("unexpected verification results: %+v", verifier)
+	}
+}
+
+func TestStamps(t *testing.T) {
+	verifier := NewVerifier(Config{Staleness: true})
+	for _, value := range []interface{}{map[string]interface{}{}, []byte("payload")} {
+		stamped, stamp := verifier.Stamp(value)
+		if stamp.Timestamp == 0 || stampOf(stamped) != stamp {
+			t.Errorf("%T: %v != %v", value, stampOf(stamped), stamp)
+		}
+	}
+}
+
+func TestModel(t *testing.T) {
+	catalog, err := databases.LoadCatalog("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ValidateCatalog(catalog); err != nil {
+		t.Fatal(err)
+	}
+	workload := N1QL{Config: config}
+	model := NewModel(catalog)
+	for i := int64(1); i <= 100; i++ {
+		model.Add(workload.GenerateNewKey(i))
+	}
+	key := workload.GenerateNewKey(5)
+	query := func(name string) []interface{} {
+		q, _ := catalog.Get(name)
+		return QueryArgs(q, key)
+	}
+
+	args := query("name_and_street_by_city")
+	if !model.Validate(args, []map[string]interface{}{{}}) {
+		t.Error("rows: valid result rejected")
+	}
+	if model.Validate(args, nil) {
+		t.Error("rows: empty result accepted")
+	}
+
+	fixed := NewModel(catalog)
+	fixed.records = map[string]map[string]interface{}{
+		"a": {"state": "CA", "year": 2000, "coins": 1.5},
+		"b": {"state": "CA", "year": 2000, "coins": 4.0},
+		"c": {"state": "CA", "year": 2000, "coins": 2.5},
+		"d": {"state": "CA", "year": 2001, "coins": 0.5},
+		"e": {"state": "NV", "year": 2000, "coins": 8.0},
+	}
+	args = []interface{}{"coins_stats_by_state_and_year", "CA", 2000}
+	row := map[string]interface{}{"count": 3.0, "sum": 8.0, "min": 1.5, "max": 4.0}
+	if !fixed.Validate(args, []map[string]interface{}{row}) {
+		t.Error("stats: valid result rejected")
+	}
+	for field, wrong := range map[string]float64{"count": 4, "sum": 8.5, "min": 0.5, "max": 8} {
+		expected := row[field]
+		row[field] = wrong
+		if fixed.Validate(args, []map[string]interface{}{row}) {
+			t.Errorf("stats: wrong %s accepted", field)
+		}
+		row[field] = expected
+	}
+	args = []interface{}{"coins_stats_by_state_and_year", "TX", 2000}
+	if !fixed.Validate(args, []map[string]interface{}{{"count": 0.0}}) || fixed.Validate(args, []map[string]interface{}{row}) {
+		t.Error("stats: empty result not validated")
+	}
+
+	args = query("distinct_years")
+	if model.Validate(args, []map[string]interface{}{{"year": 1900.0}}) {
+		t.Error("distinct: unknown value accepted")
+	}
+
+	if model.results["name_and_street_by_city"].Mismatches != 1 {
+		t.Error("mismatches are not counted")
+	}
+}
+
+func TestQueryWeights(t *testing.T) {
+	workload := N1QL{Config: Config{
+		Indexes:      []string{"distinct_years", "distinct_states", "body_by_city"},
+		QueryWeights: map[string]int{"distinct_years": 3, "distinct_states": 0},
+	}}
+	picked := map[string]int{}
+	for i := 0; i < 4000; i++ {
+		picked[workload.PickIndex()]++
+	}
+	if picked["distinct_states"] != 0 {
+		t.Error("query with zero weight picked")
+	}
+	if ratio := float64(picked["distinct_years"]) / float64(picked["body_by_city"]); ratio < 2.5 || ratio > 3.5 {
+		t.Errorf("unexpected ratio: %v", ratio)
+	}
+}
+
+func TestRecordQuery(t *testing.T) {
+	state := State{}
+	state.Init()
+	state.RecordQuery([]interface{}{"distinct_years"}, 10, nil, 1.5)
+	state.RecordQuery([]interface{}{"distinct_years"}, 0, errors.New("timeout"), 100)
+	state.RecordQuery([]interface{}{}, 3, nil, 2)
+
+	query := state.Queries["distinct_years"]
+	if query.Count != 2 || query.Errors != 1 || query.Rows.Max != 10 || query.Latency.Total() != 1 {
+		t.Errorf("unexpected stats: %+v", query)
+	}
+	if state.Queries["Query"].Count != 1 {
+		t.Error("unnamed query is not recorded")
+	}
+}
+
+func TestExpiries(t *testing.T) {
+	defer func(max int) { maxExpiries = max }(maxExpiries)
+	maxExpiries = 2
+
+	state := State{}
+	state.Init()
+	state.SetExpiry("expired", 60)
+	state.SetExpiry("live", 60)
+	state.expiries["expired"] = time.Now().Add(-time.Second)
+	if !state.CountExpiredRead("expired") || state.CountExpiredRead("live") || state.CountExpiredRead("unknown") {
+		t.Error("unexpected expired reads")
+	}
+	if state.ExpiredReads != 1 {
+		t.Errorf("expired reads: %d", state.ExpiredReads)
+	}
+
+	// The full map drops the expired key to make room for the new one.
+	state.SetExpiry("new", 60)
+	if _, ok := state.expiries["expired"]; ok || len(state.expiries) != 2 {
+		t.Errorf("expired key was not pruned: %v", state.expiries)
+	}
+	state.SetExpiry("dropped", 60)
+	if _, ok := state.expiries["dropped"]; ok {
+		t.Error("expiries are not bounded")
+	}
+	state.SetExpiry("live", 0)
+	if _, ok := state.expiries["live"]; ok {
+		t.Error("persisted key was not forgotten")
+	}
+}
+
+// probeReader fails reads of probes with the errors in turn, then succeeds
+// and ends the run.
+type probeReader struct {
+	databases.Database
+	state  *State
+	errors []error
+}
+
+func (db *probeReader) Create(key string, value interface{}, expiry int) error { return nil }
+
+func (db *probeReader) Delete(key string) error { return nil }
+
+func (db *probeReader) Read(key string) (interface{}, error) {
+	if len(db.errors) > 0 {
+		err := db.errors[0]
+		db.errors = db.errors[1:]
+		return nil, err
+	}
+	db.state.Operations = 1
+	return nil, nil
+}
+
+func TestProbeVisibility(t *testing.T) {
+	probeConfig := config
+	probeConfig.Operations = 1
+	workload := &Default{Config: probeConfig}
+	workload.SetImplementation(workload)
+	wg := sync.WaitGroup{}
+
+	state := State{}
+	state.Init()
+	db := &probeReader{state: &state, errors: []error{databases.ErrNotFound, databases.ErrNotFound}}
+	wg.Add(1)
+	state.ProbeVisibility(db, db, workload, probeConfig, &wg)
+	if state.Visibility.Total() != 1 || state.VisibilityErrors != 0 {
+		t.Errorf("visible probe: %v probes, %v errors", state.Visibility.Total(), state.VisibilityErrors)
+	}
+
+	state = State{}
+	state.Init()
+	db = &probeReader{state: &state, errors: []error{&databases.Error{Class: databases.Timeout, Err: errors.New("timeout")}}}
+	wg.Add(1)
+	state.ProbeVisibility(db, db, workload, probeConfig, &wg)
+	if state.Visibility.Total() != 1 || state.VisibilityErrors != 1 {
+		t.Errorf("failed probe: %v probes, %v errors", state.Visibility.Total(), state.VisibilityErrors)
+	}
+}
+
+func TestMergeState(t *testing.T) {
+	agents := make([]State, 2)
+	for i := range agents {
+		agents[i].Init()
+		agents[i].Operations = 10
+		agents[i].Events["Started"] = time.Unix(int64(100+i), 0)
+		agents[i].Events["Finished"] = time.Unix(int64(200+i), 0)
+		agents[i].Latency["Read"] = []float64{1, 2}
+		agents[i].RecordError("r", databases.ErrNotFound, 1.5)
+		agents[i].RecordQuery([]interface{}{"distinct_years"}, 10, nil, 1.5)
+	}
+
+	// States are sent by agents as JSON.
+	encoded, err := json.Marshal(&agents[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	agents[1] = State{}
+	if err := json.Unmarshal(encoded, &agents[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	state := State{}
+	state.Init()
+	for i := range agents {
+		state.Merge(&agents[i])
+	}
+	if state.Operations != 20 || len(state.Latency["Read"]) != 4 || state.Errors["total"] != 2 {
+		t.Errorf("unexpected totals: %v operations, %v errors", state.Operations, state.Errors)
+	}
+	if elapsed := state.Events["Finished"].Sub(state.Events["Started"]); elapsed != 101*time.Second {
+		t.Errorf("unexpected elapsed time: %v", elapsed)
+	}
+	if state.ErrorLatency["r"][databases.NotFound].Total() != 2 {
+		t.Error("error latencies are not merged")
+	}
+	if query := state.Queries["distinct_years"]; query.Count != 2 || query.Latency.Total() != 2 {
+		t.Errorf("unexpected query stats: %+v", query)
+	}
+}
+
 func BenchmarkDefaultExistingKeyGen(b *testing.B) {
 	defaultWorkload = &Default{Config: config}
 	for i := 0; i < b.N; i++ {